@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"example.com/m/internal/config"
+	"example.com/m/internal/database"
+	"example.com/m/internal/inventory"
+	"example.com/m/internal/service"
+	"example.com/m/routes"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/informers"
+)
+
+// informerResync is how often the service cache and pod resolver replay
+// their contents to catch any missed watch events.
+const informerResync = 10 * time.Minute
+
+// podSyncTimeout is how long the pod resolver may take to sync before the
+// server warns that flows are served without workloads.
+const podSyncTimeout = 2 * time.Minute
+
+// server bundles the dependencies shared by every request.
+type server struct {
+	cfg        *config.Config
+	repo       database.Repository
+	mongo      *database.MongoClient
+	k8s        *service.K8sServiceClient
+	reconciler *inventory.Reconciler
+	informers  informers.SharedInformerFactory
+	cache      *service.ServiceCache
+	pods       *service.PodResolver
+	httpServer *http.Server
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		cfg, err := config.Read()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load configuration")
+		}
+		if err := runSeed(cfg, os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal().Err(err).Msg("seed failed")
+		}
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load configuration")
+	}
+
+	srv, err := newServer(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialise server")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := srv.run(ctx); err != nil {
+		log.Fatal().Err(err).Msg("server exited with error")
+	}
+}
+
+// newServer connects to the data store and Kubernetes and builds the HTTP
+// server. A MongoDB client connected before a later step fails is
+// disconnected again.
+func newServer(cfg *config.Config) (_ *server, err error) {
+	srv := &server{cfg: cfg}
+	defer func() {
+		if err != nil && srv.mongo != nil {
+			if disconnectErr := srv.mongo.Disconnect(); disconnectErr != nil {
+				log.Error().Err(disconnectErr).Msg("failed to disconnect from MongoDB")
+			}
+		}
+	}()
+
+	switch cfg.Backend {
+	case config.BackendMemory:
+		store, err := newMemoryStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+		srv.repo = store
+	default:
+		mongoClient, err := database.NewMongoClient(cfg.MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		srv.repo = mongoClient
+		srv.mongo = mongoClient
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mongoClient.EnsureTrafficIndexes(ctx, cfg.Database, cfg.NetworkCollection); err != nil {
+			log.Warn().Err(err).Msg("failed to create traffic indexes")
+		}
+		// CIDR filters and sorts read fields older documents lack, so
+		// backfill them before serving queries.
+		if migrated, err := mongoClient.MigrateTrafficDocuments(ctx, cfg.Database, cfg.NetworkCollection); err != nil {
+			log.Warn().Err(err).Msg("failed to migrate traffic documents")
+		} else if migrated > 0 {
+			log.Info().Str("collection", cfg.NetworkCollection).Int("migrated", migrated).Msg("added computed fields to traffic documents")
+		}
+	}
+
+	// The API can serve traffic data without a cluster, so a missing
+	// kubeconfig is not fatal.
+	clientset, err := service.CreateK8sClientset()
+	if err != nil {
+		log.Warn().Err(err).Msg("Kubernetes client unavailable, continuing without it")
+	} else {
+		selectors := service.Selectors{Label: cfg.LabelSelector, Field: cfg.FieldSelector}
+		srv.k8s = service.NewK8sServiceClient(clientset, cfg.Namespace).WithSelectors(selectors)
+		srv.reconciler = inventory.NewReconciler(srv.k8s, srv.repo, inventory.Options{
+			Database:          cfg.Database,
+			ServiceCollection: cfg.ServiceCollection,
+			Interval:          cfg.ReconcileInterval,
+		})
+
+		// The service cache and pod resolver share one set of informers,
+		// so the EndpointSlices both read are watched once.
+		srv.informers = informers.NewSharedInformerFactoryWithOptions(clientset, informerResync, informers.WithNamespace(cfg.Namespace))
+		srv.cache, err = service.NewServiceCache(srv.informers, cfg.Namespace, selectors)
+		if err != nil {
+			return nil, err
+		}
+		srv.pods, err = service.NewPodResolver(srv.informers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Flows to services not yet in the inventory are attributed from the
+	// live cache and pod addresses are attributed to their workloads.
+	// Readiness waits for the service cache only: workloads are a best
+	// effort, and the pod resolver never syncs without permission to watch
+	// pods, ReplicaSets and Jobs.
+	store := srv.repo
+	readiness := map[string]func() error{}
+	if srv.cache != nil {
+		store = database.WithResolver(store, srv.cache)
+		readiness["service-cache"] = srv.cache.Ready
+	}
+	if srv.pods != nil {
+		store = database.WithPodResolver(store, srv.pods)
+	}
+	handler := routes.NewHandler(store, routes.Options{
+		Database:          cfg.Database,
+		NetworkCollection: cfg.NetworkCollection,
+		ServiceCollection: cfg.ServiceCollection,
+		ReadinessChecks:   readiness,
+	})
+	srv.httpServer = &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: routes.SetupRouter(handler),
+	}
+
+	return srv, nil
+}
+
+// run serves HTTP, and keeps the service cache, pod resolver and inventory
+// in sync with the cluster, until ctx is cancelled, then drains in-flight
+// requests and disconnects from MongoDB.
+func (s *server) run(ctx context.Context) error {
+	if s.informers != nil {
+		s.informers.Start(ctx.Done())
+	}
+	if s.reconciler != nil {
+		go s.reconciler.Run(ctx)
+	}
+	if s.pods != nil {
+		go s.reportPodSync(ctx)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info().Str("addr", s.cfg.ListenAddr).Msg("starting HTTP server")
+		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("shutdown signal received, draining requests")
+	case serveErr = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to shut down HTTP server cleanly")
+	}
+
+	if s.mongo != nil {
+		if err := s.mongo.Disconnect(); err != nil {
+			log.Error().Err(err).Msg("failed to disconnect from MongoDB")
+		}
+	}
+
+	return serveErr
+}
+
+// reportPodSync warns when the pod resolver has not synced within
+// podSyncTimeout. Until it does, flows are served without workloads.
+func (s *server) reportPodSync(ctx context.Context) {
+	syncCtx, cancel := context.WithTimeout(ctx, podSyncTimeout)
+	defer cancel()
+	if s.pods.WaitForSync(syncCtx) {
+		log.Info().Msg("pod resolver synced")
+		return
+	}
+	if ctx.Err() == nil {
+		log.Warn().
+			Dur("timeout", podSyncTimeout).
+			Msg("pod resolver has not synced, serving flows without workloads; check that pods, replicasets and jobs may be listed and watched")
+	}
+}
+
+// newMemoryStore builds an in-memory store seeded with the configured
+// fixtures, for running the API locally without MongoDB.
+func newMemoryStore(cfg *config.Config) (*database.MemoryStore, error) {
+	store := database.NewMemoryStore()
+	if err := store.LoadTrafficFile(cfg.Database, cfg.NetworkCollection, cfg.NetworkDataFile); err != nil {
+		return nil, err
+	}
+	if err := store.LoadServiceFile(cfg.Database, cfg.ServiceCollection, cfg.ServiceDataFile); err != nil {
+		return nil, err
+	}
+	log.Info().Str("database", cfg.Database).Msg("using in-memory store seeded from sample data")
+	return store, nil
+}
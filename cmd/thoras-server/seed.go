@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"example.com/m/internal/config"
+	"example.com/m/internal/database"
+	"github.com/rs/zerolog/log"
+)
+
+// seedUsage is printed for `thoras-server seed -h`.
+const seedUsage = `Usage: thoras-server seed [flags]
+
+Loads network traffic and service fixtures into MongoDB. The target
+collections are created and indexed if needed. Records are upserted by their
+natural key (service name, or flow 5-tuple and observed_at), so seeding the
+same files twice does not duplicate them. Service documents stored in the
+original layout, with a single ip_address and listening_port, are migrated
+to the ip_addresses and ports arrays the traffic queries read, and traffic
+documents missing the numeric addresses CIDR queries use get them added.
+
+Flags:
+`
+
+// runSeed implements the seed subcommand.
+func runSeed(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), seedUsage)
+		fs.PrintDefaults()
+	}
+	mongoURI := fs.String("mongo-uri", cfg.MongoURI, "MongoDB connection string")
+	dbName := fs.String("database", cfg.Database, "database to seed")
+	networkCollection := fs.String("network-collection", cfg.NetworkCollection, "collection receiving the network traffic")
+	serviceCollection := fs.String("service-collection", cfg.ServiceCollection, "collection receiving the services")
+	networkFile := fs.String("network-file", cfg.NetworkDataFile, "network traffic file (JSON array or NDJSON); empty to skip")
+	serviceFile := fs.String("service-file", cfg.ServiceDataFile, "service file (JSON array or NDJSON); empty to skip")
+	drop := fs.Bool("drop", false, "drop both collections before loading")
+	insertOnly := fs.Bool("insert-only", false, "insert every record instead of upserting by natural key")
+	batchSize := fs.Int("batch-size", database.DefaultSeedBatchSize, "documents per bulk write")
+	timeout := fs.Duration("timeout", 10*time.Minute, "time limit for the whole seed run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if *mongoURI == "" {
+		return errors.New("MONGO_URI or -mongo-uri must be set")
+	}
+
+	client, err := database.NewMongoClient(*mongoURI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *drop {
+		if err := client.DropCollections(ctx, *dbName, *networkCollection, *serviceCollection); err != nil {
+			return err
+		}
+		log.Info().Str("database", *dbName).Msg("dropped existing collections")
+	}
+	if err := client.EnsureCollections(ctx, *dbName, *networkCollection, *serviceCollection); err != nil {
+		return err
+	}
+	if err := client.EnsureTrafficIndexes(ctx, *dbName, *networkCollection); err != nil {
+		return err
+	}
+	if err := client.EnsureServiceIndexes(ctx, *dbName, *serviceCollection); err != nil {
+		return err
+	}
+
+	loads := []struct {
+		collection string
+		file       string
+		opts       database.SeedOptions
+	}{
+		{*serviceCollection, *serviceFile, database.ServiceSeedOptions()},
+		{*networkCollection, *networkFile, database.TrafficSeedOptions()},
+	}
+	for _, load := range loads {
+		if load.file == "" {
+			continue
+		}
+		load.opts.BatchSize = *batchSize
+		if *insertOnly {
+			load.opts.Key = nil
+		}
+		summary, err := client.InsertJSONData(ctx, *dbName, load.collection, load.file, load.opts)
+		if err != nil {
+			return fmt.Errorf("failed to seed %s from %s: %w", load.collection, load.file, err)
+		}
+		log.Info().
+			Str("collection", load.collection).
+			Str("file", load.file).
+			Int("inserted", summary.Inserted).
+			Int("updated", summary.Updated).
+			Int("skipped", summary.Skipped).
+			Msg("seeded collection")
+	}
+
+	migrated, err := client.MigrateServiceDocuments(ctx, *dbName, *serviceCollection)
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		log.Info().Str("collection", *serviceCollection).Int("migrated", migrated).Msg("migrated legacy service documents")
+	}
+	migrated, err = client.MigrateTrafficDocuments(ctx, *dbName, *networkCollection)
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		log.Info().Str("collection", *networkCollection).Int("migrated", migrated).Msg("added computed fields to traffic documents")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Storage backends supported by the server.
+const (
+	BackendMongo  = "mongo"
+	BackendMemory = "memory"
+)
+
+// AllNamespaces is the K8S_NAMESPACE value that watches every namespace.
+const AllNamespaces = "*"
+
+// Config holds the runtime settings for the Thoras server.
+type Config struct {
+	ListenAddr        string
+	Backend           string
+	MongoURI          string
+	Database          string
+	NetworkCollection string
+	ServiceCollection string
+	NetworkDataFile   string
+	ServiceDataFile   string
+	// Namespace is the Kubernetes namespace to watch; it is empty when
+	// K8S_NAMESPACE is AllNamespaces.
+	Namespace string
+	// LabelSelector and FieldSelector restrict which cluster services are
+	// synced and cached.
+	LabelSelector   string
+	FieldSelector   string
+	ShutdownTimeout time.Duration
+	// ReconcileInterval is how often the service inventory is synced from
+	// the cluster; zero syncs once at startup only.
+	ReconcileInterval time.Duration
+}
+
+// Load reads the server configuration from environment variables, falling
+// back to defaults for anything that is not set, and checks that the chosen
+// storage backend is usable.
+func Load() (*Config, error) {
+	cfg, err := Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read reads the configuration from environment variables like Load, but
+// leaves the storage backend settings unchecked for commands that supply
+// their own.
+func Read() (*Config, error) {
+	cfg := &Config{
+		ListenAddr:        getEnv("LISTEN_ADDR", ":8080"),
+		Backend:           getEnv("STORAGE_BACKEND", BackendMongo),
+		MongoURI:          os.Getenv("MONGO_URI"),
+		Database:          getEnv("MONGO_DATABASE", "testdb"),
+		NetworkCollection: getEnv("NETWORK_COLLECTION", "testcollectionB"),
+		ServiceCollection: getEnv("SERVICE_COLLECTION", "testcollectionA"),
+		NetworkDataFile:   getEnv("NETWORK_DATA_FILE", "sample/networkData"),
+		ServiceDataFile:   getEnv("SERVICE_DATA_FILE", "sample/serviceData"),
+		Namespace:         getEnv("K8S_NAMESPACE", "default"),
+		LabelSelector:     os.Getenv("K8S_LABEL_SELECTOR"),
+		FieldSelector:     os.Getenv("K8S_FIELD_SELECTOR"),
+		ShutdownTimeout:   15 * time.Second,
+		ReconcileInterval: 5 * time.Minute,
+	}
+
+	if cfg.Namespace == AllNamespaces {
+		cfg.Namespace = ""
+	}
+
+	if value, exists := os.LookupEnv("SHUTDOWN_TIMEOUT"); exists {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: %w", value, err)
+		}
+		cfg.ShutdownTimeout = timeout
+	}
+	if value, exists := os.LookupEnv("RECONCILE_INTERVAL"); exists {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECONCILE_INTERVAL %q: %w", value, err)
+		}
+		cfg.ReconcileInterval = interval
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the storage backend settings are usable.
+func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendMongo:
+		if c.MongoURI == "" {
+			return fmt.Errorf("MONGO_URI must be set")
+		}
+	case BackendMemory:
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", c.Backend)
+	}
+	return nil
+}
+
+// getEnv returns the value of the environment variable or the fallback if it is unset.
+func getEnv(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		return value
+	}
+	return fallback
+}
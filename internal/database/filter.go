@@ -0,0 +1,187 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"example.com/m/internal/network"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TrafficFilter narrows a traffic query to the flows a caller is interested
+// in. Zero-valued fields match every flow.
+type TrafficFilter struct {
+	Statuses         []network.TrafficStatus
+	SourcePort       int
+	DestinationPort  int
+	SourceCIDRs      []netip.Prefix
+	DestinationCIDRs []netip.Prefix
+	// Direction keeps only flows into or out of the queried service.
+	Direction Direction
+	Protocol  string
+	// Namespace keeps only flows with a service from that namespace on
+	// either end.
+	Namespace string
+	// namespaceIPs are addresses known to belong to Namespace besides those
+	// of the inventory's services, such as the ones a resolver knows. Flows
+	// to or from them are in the namespace too.
+	namespaceIPs []string
+}
+
+// ParseDirection validates a flow direction from a request.
+func ParseDirection(s string) (Direction, error) {
+	switch direction := Direction(s); direction {
+	case "", DirectionInbound, DirectionOutbound:
+		return direction, nil
+	default:
+		return "", fmt.Errorf("unsupported direction %q", s)
+	}
+}
+
+// ParseCIDR parses an IPv4 CIDR range. A bare address is treated as a
+// single-host range.
+func ParseCIDR(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(s)
+		if addrErr != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", s)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if !prefix.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("unsupported CIDR range %q: only IPv4 is supported", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// cidrBounds returns the numeric first and last address of an IPv4 range,
+// comparable with ipv4Number and ipv4NumberExpr.
+func cidrBounds(prefix netip.Prefix) (int64, int64) {
+	first := ipv4Number(prefix.Addr().String())
+	return first, first + int64(1)<<(32-prefix.Bits()) - 1
+}
+
+// matchConditions translates the filter into $match clauses on stored
+// fields, so each can be answered from an index. The subject decides a
+// flow's direction. A namespace is matched on namespaceIPs, which must
+// already hold the addresses of the namespace's services.
+func (f TrafficFilter) matchConditions(subject trafficSubject) []bson.D {
+	var clauses []bson.D
+	if len(f.Statuses) > 0 {
+		clauses = append(clauses, bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: f.Statuses}}}})
+	}
+	if f.SourcePort != 0 {
+		clauses = append(clauses, bson.D{{Key: "source_port", Value: f.SourcePort}})
+	}
+	if f.DestinationPort != 0 {
+		clauses = append(clauses, bson.D{{Key: "destination_port", Value: f.DestinationPort}})
+	}
+	if f.Protocol != "" {
+		clauses = append(clauses, bson.D{{Key: "protocol", Value: f.Protocol}})
+	}
+	switch f.Direction {
+	case DirectionInbound:
+		clauses = append(clauses, subject.condition("destination"))
+	case DirectionOutbound:
+		clauses = append(clauses, bson.D{{Key: "$nor", Value: bson.A{subject.condition("destination")}}})
+	}
+	if len(f.SourceCIDRs) > 0 {
+		clauses = append(clauses, rangeCondition("source_ip_num", f.SourceCIDRs))
+	}
+	if len(f.DestinationCIDRs) > 0 {
+		clauses = append(clauses, rangeCondition("destination_ip_num", f.DestinationCIDRs))
+	}
+	if f.Namespace != "" {
+		// $in needs an array, even for a namespace without services.
+		ips := append([]string{}, f.namespaceIPs...)
+		clauses = append(clauses, serviceFlowFilter(ips))
+	}
+	return clauses
+}
+
+// rangeCondition matches documents whose numeric IPv4 address in field
+// falls in any of the ranges.
+func rangeCondition(field string, prefixes []netip.Prefix) bson.D {
+	var ranges bson.A
+	for _, prefix := range prefixes {
+		first, last := cidrBounds(prefix)
+		ranges = append(ranges, bson.D{{Key: field, Value: bson.D{{Key: "$gte", Value: first}, {Key: "$lte", Value: last}}}})
+	}
+	if len(ranges) == 1 {
+		return ranges[0].(bson.D)
+	}
+	return bson.D{{Key: "$or", Value: ranges}}
+}
+
+// cidrExpr is true when the IPv4 address in field falls in any of the
+// ranges. It works on the address string, for use in computed fields;
+// filters use rangeCondition so they can be served by an index.
+func cidrExpr(field string, prefixes []netip.Prefix) bson.D {
+	var inRange bson.A
+	for _, prefix := range prefixes {
+		first, last := cidrBounds(prefix)
+		inRange = append(inRange, bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$gte", Value: bson.A{"$$ip", first}}},
+			bson.D{{Key: "$lte", Value: bson.A{"$$ip", last}}},
+		}}})
+	}
+	return bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{{Key: "ip", Value: ipv4NumberExpr(field)}}},
+		{Key: "in", Value: bson.D{{Key: "$or", Value: inRange}}},
+	}}}
+}
+
+// matches is the in-process equivalent of matchConditions, applied to a
+// row whose direction has already been set.
+func (f TrafficFilter) matches(row ServiceTraffic) bool {
+	switch {
+	case len(f.Statuses) > 0 && !slices.Contains(f.Statuses, row.Status):
+		return false
+	case f.SourcePort != 0 && row.SourcePort != f.SourcePort:
+		return false
+	case f.DestinationPort != 0 && row.DestinationPort != f.DestinationPort:
+		return false
+	case f.Protocol != "" && row.Protocol != f.Protocol:
+		return false
+	case f.Direction != "" && row.Direction != f.Direction:
+		return false
+	case len(f.SourceCIDRs) > 0 && !inCIDRs(row.SourceIP, f.SourceCIDRs):
+		return false
+	case len(f.DestinationCIDRs) > 0 && !inCIDRs(row.DestinationIP, f.DestinationCIDRs):
+		return false
+	case f.Namespace != "" && !inNamespace(row.SourceService, f.Namespace) && !inNamespace(row.DestinationService, f.Namespace) &&
+		!slices.Contains(f.namespaceIPs, row.SourceIP) && !slices.Contains(f.namespaceIPs, row.DestinationIP):
+		return false
+	}
+	return true
+}
+
+func inNamespace(ref *ServiceRef, namespace string) bool {
+	return ref != nil && ref.Namespace == namespace
+}
+
+// serviceMatchStage is the $match applied once flows have been joined with
+// the service inventory, for the conditions on the matched services. It
+// serves queries that join every flow anyway; paged traffic queries resolve
+// the namespace to namespaceIPs up front instead.
+func (f TrafficFilter) serviceMatchStage() (bson.D, bool) {
+	if f.Namespace == "" {
+		return nil, false
+	}
+	return bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "source_service.namespace", Value: f.Namespace}},
+		bson.D{{Key: "destination_service.namespace", Value: f.Namespace}},
+	}}}}}, true
+}
+
+func inCIDRs(ip string, prefixes []netip.Prefix) bool {
+	n := ipv4Number(ip)
+	for _, prefix := range prefixes {
+		if first, last := cidrBounds(prefix); n >= first && n <= last {
+			return true
+		}
+	}
+	return false
+}
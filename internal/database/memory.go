@@ -0,0 +1,467 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is an in-process Repository used by tests and local
+// development. It mirrors the behaviour of the MongoDB backend without
+// needing a running server.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]bool
+	traffic     map[string][]ServiceTraffic
+	services    map[string][]service.ServiceData
+}
+
+var _ Repository = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		collections: make(map[string]bool),
+		traffic:     make(map[string][]ServiceTraffic),
+		services:    make(map[string][]service.ServiceData),
+	}
+}
+
+// collectionKey identifies a collection within a database.
+func collectionKey(database, collection string) string {
+	return database + "." + collection
+}
+
+// CreateCollection registers an empty collection so queries against it succeed.
+func (s *MemoryStore) CreateCollection(database, collection string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections[collectionKey(database, collection)] = true
+}
+
+// LoadTrafficFile loads a JSON array of network traffic records, such as
+// sample/networkData, into the given collection.
+func (s *MemoryStore) LoadTrafficFile(database, collection, filePath string) error {
+	var records []network.NetworkTraffic
+	if err := readJSONDocuments(filePath, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := s.InsertTraffic(context.Background(), database, collection, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadServiceFile loads a JSON array of service documents, such as
+// sample/serviceData, into the given collection.
+func (s *MemoryStore) LoadServiceFile(database, collection, filePath string) error {
+	var records []service.ServiceData
+	if err := readJSONDocuments(filePath, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := s.InsertService(context.Background(), database, collection, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertTraffic stores a single network traffic record.
+func (s *MemoryStore) InsertTraffic(ctx context.Context, database, collection string, traffic network.NetworkTraffic) error {
+	traffic.Normalize()
+	if err := traffic.Validate(); err != nil {
+		return fmt.Errorf("invalid traffic record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := collectionKey(database, collection)
+	s.collections[key] = true
+	s.traffic[key] = append(s.traffic[key], ServiceTraffic{
+		ID:             primitive.NewObjectID(),
+		NetworkTraffic: traffic,
+	})
+	return nil
+}
+
+// InsertTrafficBatch stores every valid record and reports the invalid ones
+// by their index in records.
+func (s *MemoryStore) InsertTrafficBatch(ctx context.Context, database, collection string, records []network.NetworkTraffic) ([]RecordError, error) {
+	valid, positions, failures := validateBatch(records)
+	for i, record := range valid {
+		if err := s.InsertTraffic(ctx, database, collection, record); err != nil {
+			failures = append(failures, RecordError{Index: positions[i], Error: err.Error()})
+		}
+	}
+	return failures, nil
+}
+
+// FindTraffic returns every network traffic record in the collection.
+func (s *MemoryStore) FindTraffic(ctx context.Context, database, collection string) ([]network.NetworkTraffic, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var results []network.NetworkTraffic
+	for _, record := range s.traffic[collectionKey(database, collection)] {
+		results = append(results, record.NetworkTraffic)
+	}
+	return results, nil
+}
+
+// InsertService stores a single service inventory record.
+func (s *MemoryStore) InsertService(ctx context.Context, database, collection string, svc service.ServiceData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := collectionKey(database, collection)
+	s.collections[key] = true
+	s.services[key] = append(s.services[key], svc)
+	return nil
+}
+
+// FindServices returns every service in the inventory collection.
+func (s *MemoryStore) FindServices(ctx context.Context, database, collection string) ([]service.ServiceData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]service.ServiceData(nil), s.services[collectionKey(database, collection)]...), nil
+}
+
+// FindServiceByName returns the service with the given name in namespace,
+// or ErrServiceNotFound. An empty namespace matches any namespace, and
+// ErrAmbiguousService is returned when the name is used in several.
+func (s *MemoryStore) FindServiceByName(ctx context.Context, database, collection, namespace, name string) (*service.ServiceData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findServiceByName(collectionKey(database, collection), namespace, name)
+}
+
+// UpsertService replaces the service with the same name, namespace and
+// source, or inserts it if there is none.
+func (s *MemoryStore) UpsertService(ctx context.Context, database, collection string, svc service.ServiceData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := collectionKey(database, collection)
+	s.collections[key] = true
+	for i, existing := range s.services[key] {
+		if existing.ID() == svc.ID() && existing.Source == svc.Source {
+			s.services[key][i] = svc
+			return nil
+		}
+	}
+	s.services[key] = append(s.services[key], svc)
+	return nil
+}
+
+// MarkServicesRemoved sets RemovedAt on every service from source inside
+// scope that is not listed in keep and not already marked, and returns how
+// many it marked.
+func (s *MemoryStore) MarkServicesRemoved(ctx context.Context, database, collection, source string, scope service.Scope, keep []service.ServiceID, removedAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marked := 0
+	services := s.services[collectionKey(database, collection)]
+	for i := range services {
+		if services[i].Source != source || services[i].RemovedAt != nil || slices.Contains(keep, services[i].ID()) || !scope.Contains(services[i]) {
+			continue
+		}
+		at := removedAt
+		services[i].RemovedAt = &at
+		marked++
+	}
+	return marked, nil
+}
+
+func (s *MemoryStore) findServiceByName(key, namespace, name string) (*service.ServiceData, error) {
+	var found *service.ServiceData
+	for _, svc := range s.services[key] {
+		if svc.Name != name || (namespace != "" && svc.Namespace != namespace) || svc.RemovedAt != nil {
+			continue
+		}
+		if found == nil {
+			found = &svc
+			continue
+		}
+		if found.Namespace != svc.Namespace {
+			return nil, fmt.Errorf("%w: %s exists in several namespaces", ErrAmbiguousService, name)
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, service.ServiceID{Namespace: namespace, Name: name})
+	}
+	return found, nil
+}
+
+// AggregateTrafficWithService returns the flows to or from the named service,
+// enriched with the service on each end of the flow.
+func (s *MemoryStore) AggregateTrafficWithService(ctx context.Context, query TrafficQuery) (*TrafficPage, error) {
+	rows, err := s.serviceTraffic(query)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(query.Page, rows)
+}
+
+// StreamTrafficWithService hands each of the service's flows to fn in the
+// query's sort order, ignoring its limit and continuation token.
+func (s *MemoryStore) StreamTrafficWithService(ctx context.Context, query TrafficQuery, fn func(ServiceTraffic) error) error {
+	rows, err := s.serviceTraffic(query)
+	if err != nil {
+		return err
+	}
+	for _, row := range sortRows(query.Page, rows) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(row.ServiceTraffic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceTraffic collects the enriched flows to or from the query's subject.
+func (s *MemoryStore) serviceTraffic(query TrafficQuery) ([]ServiceTraffic, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureCollectionsExist(query.Database, query.NetworkCollection, query.ServiceCollection); err != nil {
+		return nil, err
+	}
+	serviceKey := collectionKey(query.Database, query.ServiceCollection)
+	subject, err := s.querySubject(serviceKey, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ServiceTraffic
+	for _, record := range s.traffic[collectionKey(query.Database, query.NetworkCollection)] {
+		if !subject.has(record.SourceIP) && !subject.has(record.DestinationIP) {
+			continue
+		}
+		if !inTimeRange(record.ObservedTime(), query.From, query.To) {
+			continue
+		}
+		result := s.enrich(serviceKey, record)
+		result.Direction = DirectionOutbound
+		if subject.has(record.DestinationIP) {
+			result.Direction = DirectionInbound
+		}
+		if !query.Filter.matches(result) {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// querySubject mirrors the Mongo backend's querySubject.
+func (s *MemoryStore) querySubject(serviceKey string, query TrafficQuery) (trafficSubject, error) {
+	if query.ServiceName == "" {
+		if !query.Address.IsValid() {
+			return trafficSubject{}, errors.New("traffic query needs a service name or an address range")
+		}
+		return trafficSubject{prefixes: []netip.Prefix{query.Address}}, nil
+	}
+
+	svc, err := s.findServiceByName(serviceKey, query.Namespace, query.ServiceName)
+	if err != nil {
+		return trafficSubject{}, fmt.Errorf("failed to get service IP: %w", err)
+	}
+	if len(svc.IPs) == 0 {
+		return trafficSubject{}, fmt.Errorf("service %s has no IP addresses", query.ServiceName)
+	}
+	return trafficSubject{ips: svc.IPs}, nil
+}
+
+// SummarizeFlows counts the flows between each pair of endpoints, keeping
+// only the flows in the query's namespace when it has one.
+func (s *MemoryStore) SummarizeFlows(ctx context.Context, query FlowQuery) ([]FlowSummary, error) {
+	collections := query.Collections
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureCollectionsExist(collections.Database, collections.NetworkCollection, collections.ServiceCollection); err != nil {
+		return nil, err
+	}
+	serviceKey := collectionKey(collections.Database, collections.ServiceCollection)
+
+	type pair struct{ source, destination Endpoint }
+	index := make(map[pair]int)
+	var results []FlowSummary
+	for _, record := range s.traffic[collectionKey(collections.Database, collections.NetworkCollection)] {
+		record = s.enrich(serviceKey, record)
+		if !query.namespaceFilter().matches(record) {
+			continue
+		}
+		key := pair{endpointOf(record.SourceService, record.SourceIP), endpointOf(record.DestinationService, record.DestinationIP)}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, FlowSummary{Source: key.source, Destination: key.destination})
+		}
+		results[i].Flows++
+		if !slices.Contains(results[i].Statuses, record.Status) {
+			results[i].Statuses = append(results[i].Statuses, record.Status)
+		}
+		widenSeen(&results[i].FirstSeen, &results[i].LastSeen, record.ObservedTime())
+	}
+	return results, nil
+}
+
+// TrafficStats counts the service's flows per UTC time bucket and status,
+// leaving out flows without an observed_at time.
+func (s *MemoryStore) TrafficStats(ctx context.Context, query StatsQuery) ([]TrafficBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureCollectionsExist(query.Database, query.NetworkCollection, query.ServiceCollection); err != nil {
+		return nil, err
+	}
+	svc, err := s.findServiceByName(collectionKey(query.Database, query.ServiceCollection), query.Namespace, query.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service IP: %w", err)
+	}
+	if len(svc.IPs) == 0 {
+		return nil, fmt.Errorf("service %s has no IP addresses", query.ServiceName)
+	}
+
+	type group struct {
+		start  time.Time
+		status network.TrafficStatus
+	}
+	var rows []statsRow
+	index := make(map[group]int)
+	for _, record := range s.traffic[collectionKey(query.Database, query.NetworkCollection)] {
+		if !svc.HasIP(record.SourceIP) && !svc.HasIP(record.DestinationIP) {
+			continue
+		}
+		if record.ObservedTime().IsZero() || !inTimeRange(record.ObservedTime(), query.From, query.To) {
+			continue
+		}
+		key := group{query.Bucket.truncate(record.ObservedTime()), record.Status}
+		i, ok := index[key]
+		if !ok {
+			i = len(rows)
+			index[key] = i
+			rows = append(rows, statsRow{})
+			rows[i].ID.Start, rows[i].ID.Status = key.start, key.status
+		}
+		rows[i].Count++
+	}
+	return collectBuckets(rows), nil
+}
+
+// FindUnknownEndpoints lists the addresses seen in the network collection
+// that no service claims, scanning only the flows in the query's namespace
+// when it has one.
+func (s *MemoryStore) FindUnknownEndpoints(ctx context.Context, query FlowQuery) (*UnknownEndpoints, error) {
+	collections := query.Collections
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureCollectionsExist(collections.Database, collections.NetworkCollection, collections.ServiceCollection); err != nil {
+		return nil, err
+	}
+	serviceKey := collectionKey(collections.Database, collections.ServiceCollection)
+
+	index := make(map[string]int)
+	var endpoints []UnknownEndpoint
+	for _, record := range s.traffic[collectionKey(collections.Database, collections.NetworkCollection)] {
+		if query.Namespace != "" && !query.namespaceFilter().matches(s.enrich(serviceKey, record)) {
+			continue
+		}
+		for _, ip := range slices.Compact([]string{record.SourceIP, record.DestinationIP}) {
+			if s.lookupServiceByIP(serviceKey, ip, 0) != nil {
+				continue
+			}
+			i, ok := index[ip]
+			if !ok {
+				i = len(endpoints)
+				index[ip] = i
+				endpoints = append(endpoints, UnknownEndpoint{IP: ip})
+			}
+			endpoints[i].Flows++
+			widenSeen(&endpoints[i].FirstSeen, &endpoints[i].LastSeen, record.ObservedTime())
+		}
+	}
+	sortUnknownEndpoints(endpoints)
+	return splitUnknownEndpoints(endpoints), nil
+}
+
+// inTimeRange mirrors timeRangeFilter: t must fall in [from, to), and flows
+// without a timestamp only match an open range.
+func inTimeRange(t, from, to time.Time) bool {
+	if (!from.IsZero() || !to.IsZero()) && t.IsZero() {
+		return false
+	}
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
+func endpointOf(ref *ServiceRef, ip string) Endpoint {
+	if ref == nil {
+		return Endpoint{IP: ip}
+	}
+	return Endpoint{Service: ref.Name, Namespace: ref.Namespace}
+}
+
+// enrich attaches the service on each side of the flow, as the
+// serviceLookupStages pipeline does.
+func (s *MemoryStore) enrich(serviceKey string, record ServiceTraffic) ServiceTraffic {
+	record.SourceService = s.lookupServiceByIP(serviceKey, record.SourceIP, record.SourcePort)
+	record.DestinationService = s.lookupServiceByIP(serviceKey, record.DestinationIP, record.DestinationPort)
+	return record
+}
+
+// lookupServiceByIP finds the live service listening on ip, skipping
+// services marked as removed as liveServiceLookup does.
+func (s *MemoryStore) lookupServiceByIP(serviceKey, ip string, port int) *ServiceRef {
+	for _, svc := range s.services[serviceKey] {
+		if svc.HasIP(ip) && svc.RemovedAt == nil {
+			return &ServiceRef{Name: svc.Name, Namespace: svc.Namespace, IP: ip, Port: svc.PortFor(ip, int32(port))}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ensureCollectionsExist(database string, collections ...string) error {
+	for _, name := range collections {
+		if !s.collections[collectionKey(database, name)] {
+			return fmt.Errorf("%w: %s.%s", ErrCollectionNotFound, database, name)
+		}
+	}
+	return nil
+}
+
+// readJSONDocuments decodes a file holding a JSON array of documents into
+// results, parsing it the same way InsertJSONData does.
+func readJSONDocuments(filePath string, results interface{}) error {
+	docs, err := readExtJSONFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Round-trip through BSON so the struct tags used by the Mongo backend
+	// drive decoding here as well.
+	raw, err := bson.Marshal(bson.D{{Key: "docs", Value: docs}})
+	if err != nil {
+		return fmt.Errorf("failed to encode documents: %w", err)
+	}
+	return bson.Raw(raw).Lookup("docs").Unmarshal(results)
+}
@@ -0,0 +1,603 @@
+package database
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+var sampleCollections = Collections{
+	Database:          "testdb",
+	NetworkCollection: "testcollectionB",
+	ServiceCollection: "testcollectionA",
+}
+
+func newSampleStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	store := NewMemoryStore()
+	if err := store.LoadTrafficFile("testdb", "testcollectionB", "../../sample/networkData"); err != nil {
+		t.Fatalf("Failed to load network fixture: %v", err)
+	}
+	if err := store.LoadServiceFile("testdb", "testcollectionA", "../../sample/serviceData"); err != nil {
+		t.Fatalf("Failed to load service fixture: %v", err)
+	}
+	return store
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := newSampleStore(t)
+
+	t.Run("LoadFixtures", func(t *testing.T) {
+		traffic, err := store.FindTraffic(ctx, "testdb", "testcollectionB")
+		assert.NoError(t, err)
+		assert.Len(t, traffic, 8)
+		assert.Equal(t, network.StatusCritical, traffic[7].Status)
+		assert.Equal(t, time.Date(2024, 11, 20, 11, 5, 44, 0, time.UTC), traffic[7].ObservedTime())
+		assert.Equal(t, int64(30000), traffic[7].DurationMillis)
+
+		services, err := store.FindServices(ctx, "testdb", "testcollectionA")
+		assert.NoError(t, err)
+		assert.Len(t, services, 6)
+	})
+
+	t.Run("FindServiceByName", func(t *testing.T) {
+		svc, err := store.FindServiceByName(ctx, "testdb", "testcollectionA", "", "Auth")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.128.72.20"}, svc.IPs)
+		assert.Equal(t, int32(443), svc.Ports[0].Port)
+
+		_, err = store.FindServiceByName(ctx, "testdb", "testcollectionA", "", "Missing")
+		assert.ErrorIs(t, err, ErrServiceNotFound)
+	})
+
+	t.Run("Namespaces", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.CreateCollection("testdb", "traffic")
+		for _, svc := range []service.ServiceData{
+			{Name: "auth", Namespace: "prod", IPs: []string{"10.0.1.10"}},
+			{Name: "auth", Namespace: "staging", IPs: []string{"10.0.2.10"}},
+			{Name: "web", Namespace: "prod", IPs: []string{"10.0.1.20"}},
+		} {
+			assert.NoError(t, store.InsertService(ctx, "testdb", "services", svc))
+		}
+		for _, record := range []network.NetworkTraffic{
+			{SourceIP: "10.0.1.20", SourcePort: 40000, DestinationIP: "10.0.1.10", DestinationPort: 443, Status: network.StatusOK},
+			{SourceIP: "10.0.1.20", SourcePort: 40001, DestinationIP: "10.0.2.10", DestinationPort: 443, Status: network.StatusOK},
+		} {
+			assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", record))
+		}
+		collections := Collections{Database: "testdb", NetworkCollection: "traffic", ServiceCollection: "services"}
+
+		_, err := store.FindServiceByName(ctx, "testdb", "services", "", "auth")
+		assert.ErrorIs(t, err, ErrAmbiguousService)
+		svc, err := store.FindServiceByName(ctx, "testdb", "services", "staging", "auth")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.0.2.10"}, svc.IPs)
+		_, err = store.FindServiceByName(ctx, "testdb", "services", "dev", "auth")
+		assert.ErrorIs(t, err, ErrServiceNotFound)
+
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{Collections: collections, ServiceName: "auth", Namespace: "staging"})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "staging", page.Items[0].DestinationService.Namespace)
+		}
+
+		page, err = store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: collections,
+			ServiceName: "web",
+			Filter:      TrafficFilter{Namespace: "staging"},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "10.0.2.10", page.Items[0].DestinationIP)
+		}
+
+		summaries, err := store.SummarizeFlows(ctx, FlowQuery{Collections: collections})
+		assert.NoError(t, err)
+		assert.Len(t, summaries, 2, "same-named services in different namespaces stay apart")
+
+		assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", network.NetworkTraffic{
+			SourceIP: "10.0.9.9", SourcePort: 40002, DestinationIP: "10.0.2.10", DestinationPort: 443, Status: network.StatusOK,
+		}))
+		summaries, err = store.SummarizeFlows(ctx, FlowQuery{Collections: collections, Namespace: "staging"})
+		assert.NoError(t, err)
+		if assert.Len(t, summaries, 2) {
+			for _, summary := range summaries {
+				assert.Equal(t, Endpoint{Service: "auth", Namespace: "staging"}, summary.Destination)
+			}
+		}
+		endpoints, err := store.FindUnknownEndpoints(ctx, FlowQuery{Collections: collections, Namespace: "staging"})
+		assert.NoError(t, err)
+		if assert.Len(t, endpoints.Internal, 1) {
+			assert.Equal(t, "10.0.9.9", endpoints.Internal[0].IP)
+		}
+		endpoints, err = store.FindUnknownEndpoints(ctx, FlowQuery{Collections: collections, Namespace: "prod"})
+		assert.NoError(t, err)
+		assert.Empty(t, endpoints.Internal)
+
+		marked, err := store.MarkServicesRemoved(ctx, "testdb", "services", "", service.Scope{Namespace: "prod"}, nil, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, marked, "services outside the scope's namespace are left alone")
+
+		marked, err = store.MarkServicesRemoved(ctx, "testdb", "services", "", service.Scope{}, nil, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, marked)
+	})
+
+	t.Run("RemovedServicesIgnored", func(t *testing.T) {
+		store := NewMemoryStore()
+		removed := time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC)
+		for _, svc := range []service.ServiceData{
+			{Name: "old-auth", Namespace: "prod", IPs: []string{"10.0.1.10"}, RemovedAt: &removed},
+			{Name: "auth", Namespace: "prod", IPs: []string{"10.0.1.10"}},
+		} {
+			assert.NoError(t, store.InsertService(ctx, "testdb", "services", svc))
+		}
+		assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", network.NetworkTraffic{
+			SourceIP: "10.0.1.20", SourcePort: 40000, DestinationIP: "10.0.1.10", DestinationPort: 443, Status: network.StatusOK,
+		}))
+		collections := Collections{Database: "testdb", NetworkCollection: "traffic", ServiceCollection: "services"}
+
+		_, err := store.FindServiceByName(ctx, "testdb", "services", "prod", "old-auth")
+		assert.ErrorIs(t, err, ErrServiceNotFound)
+
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{Collections: collections, ServiceName: "auth"})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) && assert.NotNil(t, page.Items[0].DestinationService) {
+			assert.Equal(t, "auth", page.Items[0].DestinationService.Name)
+		}
+
+		summaries, err := store.SummarizeFlows(ctx, FlowQuery{Collections: collections})
+		assert.NoError(t, err)
+		if assert.Len(t, summaries, 1) {
+			assert.Equal(t, "auth", summaries[0].Destination.Service)
+		}
+	})
+
+	t.Run("AggregateTrafficWithService", func(t *testing.T) {
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: sampleCollections,
+			ServiceName: "Gaming UI",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), page.Total)
+		assert.Empty(t, page.ContinuationToken)
+		results := page.Items
+		assert.Len(t, results, 3)
+		for _, result := range results {
+			assert.False(t, result.ID.IsZero())
+		}
+
+		inbound := results[0]
+		assert.Equal(t, DirectionInbound, inbound.Direction)
+		assert.Nil(t, inbound.SourceService, "public IPs have no service")
+		assert.Equal(t, "Gaming UI", inbound.DestinationService.Name)
+
+		outbound := results[2]
+		assert.Equal(t, DirectionOutbound, outbound.Direction)
+		assert.Equal(t, "Gaming UI", outbound.SourceService.Name)
+		assert.Equal(t, "Gaming Service", outbound.DestinationService.Name)
+		assert.Equal(t, "game", outbound.DestinationService.Port.Name)
+		assert.Equal(t, int32(2600), outbound.DestinationService.Port.Port)
+	})
+
+	t.Run("MultipleIPsAndPorts", func(t *testing.T) {
+		store := NewMemoryStore()
+		assert.NoError(t, store.InsertService(ctx, "db", "services", service.ServiceData{
+			Name: "api",
+			IPs:  []string{"10.0.0.1", "10.1.0.7"},
+			Ports: []service.ServicePort{
+				{Name: "http", Port: 8080, Protocol: "TCP"},
+				{Name: "metrics", Port: 9090, Protocol: "TCP"},
+			},
+			Endpoints: []service.ServiceEndpoint{
+				{IP: "10.1.0.7", Ports: []service.ServicePort{{Name: "http", Port: 8000, Protocol: "TCP"}}},
+			},
+		}))
+		assert.NoError(t, store.InsertTraffic(ctx, "db", "traffic", network.NetworkTraffic{
+			SourceIP: "10.2.0.1", SourcePort: 40000, DestinationIP: "10.1.0.7", DestinationPort: 8000, Status: network.StatusOK,
+		}))
+		assert.NoError(t, store.InsertTraffic(ctx, "db", "traffic", network.NetworkTraffic{
+			SourceIP: "10.2.0.1", SourcePort: 40001, DestinationIP: "10.9.9.9", DestinationPort: 8080, Status: network.StatusOK,
+		}))
+
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: Collections{Database: "db", NetworkCollection: "traffic", ServiceCollection: "services"},
+			ServiceName: "api",
+		})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+		assert.Equal(t, "10.1.0.7", page.Items[0].DestinationService.IP)
+		assert.Equal(t, "http", page.Items[0].DestinationService.Port.Name)
+		assert.Equal(t, int32(8000), page.Items[0].DestinationService.Port.Port)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		query := TrafficQuery{
+			Collections: sampleCollections,
+			ServiceName: "Login Service",
+			Page:        Page{Limit: 3, Sort: SortBySourceIP},
+		}
+		first, err := store.AggregateTrafficWithService(ctx, query)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), first.Total)
+		assert.Len(t, first.Items, 3)
+		assert.Equal(t, "10.128.72.69", first.Items[0].SourceIP)
+		assert.Equal(t, "103.38.66.206", first.Items[1].SourceIP)
+		assert.NotEmpty(t, first.ContinuationToken)
+
+		query.Page.After = first.ContinuationToken
+		second, err := store.AggregateTrafficWithService(ctx, query)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), second.Total)
+		assert.Len(t, second.Items, 1)
+		assert.Equal(t, "172.135.84.153", second.Items[0].SourceIP)
+		assert.Empty(t, second.ContinuationToken)
+
+		query.Page.Sort = SortByStatus
+		_, err = store.AggregateTrafficWithService(ctx, query)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("SortByStatusDescending", func(t *testing.T) {
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: sampleCollections,
+			ServiceName: "Gaming UI",
+			Page:        Page{Sort: SortByStatus, Descending: true},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, network.StatusCritical, page.Items[0].Status)
+	})
+
+	t.Run("TimeRange", func(t *testing.T) {
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: sampleCollections,
+			ServiceName: "Login Service",
+			From:        time.Date(2024, 11, 20, 9, 30, 0, 0, time.UTC),
+			To:          time.Date(2024, 11, 20, 10, 27, 20, 0, time.UTC),
+		})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 2)
+		assert.Equal(t, "172.135.84.153", page.Items[0].SourceIP)
+		assert.Equal(t, int64(4096), page.Items[1].Bytes)
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: sampleCollections,
+			ServiceName: "Login Service",
+			Filter: TrafficFilter{
+				Statuses:    []network.TrafficStatus{network.StatusOK},
+				SourceCIDRs: []netip.Prefix{netip.MustParsePrefix("103.0.0.0/8"), netip.MustParsePrefix("172.135.0.0/16")},
+				Direction:   DirectionInbound,
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, "172.135.84.153", page.Items[0].SourceIP)
+			assert.Equal(t, "103.38.66.206", page.Items[1].SourceIP)
+		}
+	})
+
+	t.Run("ProtocolNormalized", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.CreateCollection("testdb", "services")
+		record := network.NetworkTraffic{SourceIP: "10.0.0.1", SourcePort: 40000, DestinationIP: "10.0.0.2", DestinationPort: 443, Status: network.StatusOK, Protocol: "tcp"}
+		failures, err := store.InsertTrafficBatch(ctx, "testdb", "flows", []network.NetworkTraffic{record, {SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2", Status: network.StatusOK, Protocol: "icmp"}})
+		assert.NoError(t, err)
+		if assert.Len(t, failures, 1) {
+			assert.Equal(t, 1, failures[0].Index)
+		}
+
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: Collections{Database: "testdb", NetworkCollection: "flows", ServiceCollection: "services"},
+			Address:     netip.MustParsePrefix("10.0.0.2/32"),
+			Filter:      TrafficFilter{Protocol: "TCP"},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "TCP", page.Items[0].Protocol)
+		}
+	})
+
+	t.Run("Address", func(t *testing.T) {
+		page, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: sampleCollections,
+			Address:     netip.MustParsePrefix("10.128.72.14/32"),
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 3) {
+			assert.Equal(t, DirectionInbound, page.Items[0].Direction)
+			assert.Equal(t, DirectionOutbound, page.Items[2].Direction)
+			assert.Equal(t, "Gaming Service", page.Items[2].DestinationService.Name)
+		}
+
+		_, err = store.AggregateTrafficWithService(ctx, TrafficQuery{Collections: sampleCollections})
+		assert.Error(t, err)
+	})
+
+	t.Run("WithResolver", func(t *testing.T) {
+		resolver := staticResolver{"121.23.41.1": {Name: "Edge Gateway"}}
+		repo := WithResolver(store, resolver)
+		query := TrafficQuery{Collections: sampleCollections, ServiceName: "Login Service"}
+
+		page, err := repo.AggregateTrafficWithService(ctx, query)
+		assert.NoError(t, err)
+		var resolved *ServiceTraffic
+		for i := range page.Items {
+			if page.Items[i].SourceIP == "121.23.41.1" {
+				resolved = &page.Items[i]
+			}
+		}
+		if assert.NotNil(t, resolved) && assert.NotNil(t, resolved.SourceService) {
+			assert.Equal(t, &ServiceRef{Name: "Edge Gateway", IP: "121.23.41.1"}, resolved.SourceService)
+		}
+
+		streamed := 0
+		err = repo.StreamTrafficWithService(ctx, query, func(row ServiceTraffic) error {
+			if row.SourceIP == "121.23.41.1" {
+				assert.NotNil(t, row.SourceService)
+				streamed++
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, streamed)
+
+		summaries, err := repo.SummarizeFlows(ctx, FlowQuery{Collections: sampleCollections})
+		assert.NoError(t, err)
+		gateway := 0
+		for _, summary := range summaries {
+			assert.NotEqual(t, "121.23.41.1", summary.Source.IP)
+			if summary.Source.Service == "Edge Gateway" {
+				gateway += summary.Flows
+			}
+		}
+		assert.Equal(t, 1, gateway)
+
+		endpoints, err := repo.FindUnknownEndpoints(ctx, FlowQuery{Collections: sampleCollections})
+		assert.NoError(t, err)
+		for _, endpoint := range endpoints.External {
+			assert.NotEqual(t, "121.23.41.1", endpoint.IP)
+		}
+	})
+
+	t.Run("WithResolverMergesSummaries", func(t *testing.T) {
+		store := NewMemoryStore()
+		assert.NoError(t, store.InsertService(ctx, "testdb", "services", service.ServiceData{Name: "db", IPs: []string{"10.0.0.9"}}))
+		for _, record := range []network.NetworkTraffic{
+			{SourceIP: "10.0.1.1", SourcePort: 40000, DestinationIP: "10.0.0.9", DestinationPort: 5432, Status: network.StatusOK},
+			{SourceIP: "10.0.1.2", SourcePort: 40001, DestinationIP: "10.0.0.9", DestinationPort: 5432, Status: network.StatusCritical},
+		} {
+			assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", record))
+		}
+		collections := Collections{Database: "testdb", NetworkCollection: "traffic", ServiceCollection: "services"}
+		repo := WithResolver(store, staticResolver{
+			"10.0.1.1": {Name: "api", Namespace: "prod"},
+			"10.0.1.2": {Name: "api", Namespace: "prod"},
+		})
+
+		summaries, err := repo.SummarizeFlows(ctx, FlowQuery{Collections: collections})
+		assert.NoError(t, err)
+		assert.Equal(t, []FlowSummary{{
+			Source:      Endpoint{Service: "api", Namespace: "prod"},
+			Destination: Endpoint{Service: "db"},
+			Flows:       2,
+			Statuses:    []network.TrafficStatus{network.StatusOK, network.StatusCritical},
+		}}, summaries)
+
+		endpoints, err := repo.FindUnknownEndpoints(ctx, FlowQuery{Collections: collections})
+		assert.NoError(t, err)
+		assert.Empty(t, endpoints.Internal)
+	})
+
+	t.Run("WithResolverNamespace", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.CreateCollection("testdb", "services")
+		observed := time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC)
+		later := observed.Add(time.Hour)
+		for _, record := range []network.NetworkTraffic{
+			{SourceIP: "10.0.9.9", SourcePort: 40000, DestinationIP: "10.0.1.10", DestinationPort: 443, Status: network.StatusOK, ObservedAt: &observed},
+			{SourceIP: "10.0.9.9", SourcePort: 40001, DestinationIP: "10.0.1.10", DestinationPort: 443, Status: network.StatusOK, ObservedAt: &later},
+			{SourceIP: "10.0.9.8", SourcePort: 40002, DestinationIP: "10.0.2.10", DestinationPort: 443, Status: network.StatusOK},
+		} {
+			assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", record))
+		}
+		query := FlowQuery{
+			Collections: Collections{Database: "testdb", NetworkCollection: "traffic", ServiceCollection: "services"},
+			Namespace:   "prod",
+		}
+		repo := WithResolver(store, staticResolver{
+			"10.0.1.10": {Name: "auth", Namespace: "prod"},
+			"10.0.2.10": {Name: "auth", Namespace: "staging"},
+		})
+
+		summaries, err := repo.SummarizeFlows(ctx, query)
+		assert.NoError(t, err)
+		assert.Equal(t, []FlowSummary{{
+			Source:      Endpoint{IP: "10.0.9.9"},
+			Destination: Endpoint{Service: "auth", Namespace: "prod"},
+			Flows:       2,
+			Statuses:    []network.TrafficStatus{network.StatusOK},
+			FirstSeen:   observed,
+			LastSeen:    observed.Add(time.Hour),
+		}}, summaries, "flows attributed only by the resolver are kept")
+
+		endpoints, err := repo.FindUnknownEndpoints(ctx, query)
+		assert.NoError(t, err)
+		assert.Equal(t, []UnknownEndpoint{{IP: "10.0.9.9", Flows: 2, FirstSeen: observed, LastSeen: observed.Add(time.Hour)}}, endpoints.Internal)
+		assert.Empty(t, endpoints.External)
+
+		traffic := TrafficQuery{
+			Collections: query.Collections,
+			Address:     netip.MustParsePrefix("10.0.0.0/16"),
+			Filter:      TrafficFilter{Namespace: "prod"},
+			Page:        Page{Limit: 1},
+		}
+		page, err := repo.AggregateTrafficWithService(ctx, traffic)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+		if assert.Len(t, page.Items, 1) && assert.NotNil(t, page.Items[0].DestinationService) {
+			assert.Equal(t, "prod", page.Items[0].DestinationService.Namespace)
+		}
+		assert.NotEmpty(t, page.ContinuationToken)
+
+		traffic.Page.After = page.ContinuationToken
+		next, err := repo.AggregateTrafficWithService(ctx, traffic)
+		assert.NoError(t, err)
+		if assert.Len(t, next.Items, 1) {
+			assert.NotEqual(t, page.Items[0].SourcePort, next.Items[0].SourcePort)
+		}
+		assert.Empty(t, next.ContinuationToken)
+
+		streamed := 0
+		err = repo.StreamTrafficWithService(ctx, traffic, func(row ServiceTraffic) error {
+			assert.Equal(t, "10.0.1.10", row.DestinationIP)
+			streamed++
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, streamed)
+	})
+
+	t.Run("WithPodResolver", func(t *testing.T) {
+		gateway := &service.Workload{Kind: service.KindDeployment, Name: "edge-gateway"}
+		pods := staticPodResolver{"121.23.41.1": {Name: "edge-gateway-7d9f-x2x", Workload: gateway}}
+		repo := WithPodResolver(store, pods)
+
+		page, err := repo.AggregateTrafficWithService(ctx, TrafficQuery{Collections: sampleCollections, ServiceName: "Login Service"})
+		assert.NoError(t, err)
+		attributed := 0
+		for _, row := range page.Items {
+			if row.SourceIP == "121.23.41.1" {
+				assert.Equal(t, gateway, row.SourceWorkload)
+				attributed++
+			} else {
+				assert.Nil(t, row.SourceWorkload)
+			}
+			assert.Nil(t, row.DestinationWorkload)
+		}
+		assert.Equal(t, 1, attributed)
+
+		summaries, err := repo.SummarizeFlows(ctx, FlowQuery{Collections: sampleCollections})
+		assert.NoError(t, err)
+		attributed = 0
+		for _, summary := range summaries {
+			if summary.Source.IP == "121.23.41.1" {
+				assert.Equal(t, gateway, summary.Source.Workload)
+				attributed++
+			} else {
+				assert.Nil(t, summary.Source.Workload)
+			}
+		}
+		assert.Equal(t, 1, attributed)
+
+		endpoints, err := repo.FindUnknownEndpoints(ctx, FlowQuery{Collections: sampleCollections})
+		assert.NoError(t, err)
+		attributed = 0
+		for _, endpoint := range endpoints.External {
+			if endpoint.IP == "121.23.41.1" {
+				assert.Equal(t, gateway, endpoint.Workload)
+				attributed++
+			}
+		}
+		assert.Equal(t, 1, attributed)
+	})
+
+	t.Run("TrafficStats", func(t *testing.T) {
+		buckets, err := store.TrafficStats(ctx, StatsQuery{
+			Collections: sampleCollections,
+			ServiceName: "Gaming UI",
+			Bucket:      BucketHour,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []TrafficBucket{
+			{Start: time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC), Total: 1, Statuses: map[network.TrafficStatus]int{network.StatusOK: 1}},
+			{Start: time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC), Total: 1, Statuses: map[network.TrafficStatus]int{network.StatusOK: 1}},
+			{Start: time.Date(2024, 11, 20, 11, 0, 0, 0, time.UTC), Total: 1, Statuses: map[network.TrafficStatus]int{network.StatusCritical: 1}},
+		}, buckets)
+
+		buckets, err = store.TrafficStats(ctx, StatsQuery{
+			Collections: sampleCollections,
+			ServiceName: "Gaming UI",
+			Bucket:      BucketDay,
+			From:        time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []TrafficBucket{
+			{Start: time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC), Total: 2, Statuses: map[network.TrafficStatus]int{network.StatusOK: 1, network.StatusCritical: 1}},
+		}, buckets)
+	})
+
+	t.Run("FindUnknownEndpoints", func(t *testing.T) {
+		store := newSampleStore(t)
+		for _, observed := range []time.Time{
+			time.Date(2024, 11, 20, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 11, 20, 8, 0, 0, 0, time.UTC),
+		} {
+			assert.NoError(t, store.InsertTraffic(ctx, "testdb", "testcollectionB", network.NetworkTraffic{
+				SourceIP: "10.128.99.5", SourcePort: 51000, DestinationIP: "10.128.72.69", DestinationPort: 443,
+				Status: network.StatusOK, ObservedAt: &observed,
+			}))
+		}
+
+		endpoints, err := store.FindUnknownEndpoints(ctx, FlowQuery{Collections: sampleCollections})
+		assert.NoError(t, err)
+		assert.Equal(t, []UnknownEndpoint{{
+			IP:        "10.128.99.5",
+			Flows:     2,
+			FirstSeen: time.Date(2024, 11, 20, 8, 0, 0, 0, time.UTC),
+			LastSeen:  time.Date(2024, 11, 20, 12, 0, 0, 0, time.UTC),
+		}}, endpoints.Internal)
+		if assert.Len(t, endpoints.External, 5) {
+			assert.Equal(t, "103.38.66.206", endpoints.External[0].IP)
+			assert.Equal(t, 1, endpoints.External[0].Flows)
+		}
+	})
+
+	t.Run("MissingCollection", func(t *testing.T) {
+		_, err := store.AggregateTrafficWithService(ctx, TrafficQuery{
+			Collections: Collections{Database: "testdb", NetworkCollection: "testcollectionB", ServiceCollection: "missing"},
+			ServiceName: "Gaming UI",
+		})
+		assert.ErrorIs(t, err, ErrCollectionNotFound)
+	})
+}
+
+// staticResolver resolves IP addresses from a fixed map.
+type staticResolver map[string]service.ServiceData
+
+func (r staticResolver) LookupIP(ip string) (*service.ServiceData, bool) {
+	svc, ok := r[ip]
+	if !ok {
+		return nil, false
+	}
+	return &svc, true
+}
+
+func (r staticResolver) NamespaceIPs(namespace string) []string {
+	var ips []string
+	for ip, svc := range r {
+		if svc.Namespace == namespace {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// staticPodResolver resolves IP addresses to pods from a fixed map.
+type staticPodResolver map[string]service.PodData
+
+func (r staticPodResolver) LookupPod(ip string) (*service.PodData, bool) {
+	pod, ok := r[ip]
+	if !ok {
+		return nil, false
+	}
+	return &pod, true
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateServiceDocuments rewrites service documents stored in the original
+// single-address layout, with an ip_address string and a listening_port
+// number, into the ip_addresses and ports arrays the traffic join reads.
+// Documents already holding the new fields keep them. It returns how many
+// documents were rewritten and is safe to call repeatedly.
+func (m *MongoClient) MigrateServiceDocuments(ctx context.Context, database, collection string) (int, error) {
+	filter, update := legacyServiceMigration()
+	result, err := m.client.Database(database).Collection(collection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate service documents: %w", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// legacyServiceMigration returns the filter selecting documents in the old
+// service layout and the pipeline update converting them.
+func legacyServiceMigration() (bson.D, mongo.Pipeline) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "ip_address", Value: bson.D{{Key: "$exists", Value: true}}}},
+		bson.D{{Key: "listening_port", Value: bson.D{{Key: "$exists", Value: true}}}},
+	}}}
+
+	// wrap turns the legacy field into a one-element array holding elem, or
+	// an empty array when the field is missing or null.
+	wrap := func(field string, elem interface{}) bson.D {
+		return bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$" + field, nil}}}, nil}}},
+			bson.A{},
+			bson.A{elem},
+		}}}
+	}
+	update := mongo.Pipeline{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "ip_addresses", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+				"$ip_addresses", wrap("ip_address", "$ip_address"),
+			}}}},
+			{Key: "ports", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+				"$ports", wrap("listening_port", bson.D{{Key: "port", Value: "$listening_port"}}),
+			}}}},
+		}}},
+		bson.D{{Key: "$unset", Value: bson.A{"ip_address", "listening_port"}}},
+	}
+	return filter, update
+}
+
+// MigrateTrafficDocuments adds the numeric source_ip_num and
+// destination_ip_num fields that CIDR queries match on, and the severity
+// field status sorts use, to network traffic documents written without
+// them. It returns how many documents were updated and is safe to call
+// repeatedly.
+func (m *MongoClient) MigrateTrafficDocuments(ctx context.Context, database, collection string) (int, error) {
+	filter, update := trafficDocumentMigration()
+	result, err := m.client.Database(database).Collection(collection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate traffic documents: %w", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// trafficDocumentMigration returns the filter selecting traffic documents
+// missing a computed field and the pipeline update computing them.
+func trafficDocumentMigration() (bson.D, mongo.Pipeline) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "source_ip_num", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "destination_ip_num", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "severity", Value: bson.D{{Key: "$exists", Value: false}}}},
+	}}}
+	update := mongo.Pipeline{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "source_ip_num", Value: ipv4NumberExpr("$source_ip")},
+			{Key: "destination_ip_num", Value: ipv4NumberExpr("$destination_ip")},
+			{Key: "severity", Value: severityExpr("$status")},
+		}}},
+	}
+	return filter, update
+}
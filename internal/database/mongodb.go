@@ -2,132 +2,533 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"example.com/m/internal/service"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func AggregateTrafficWithService(ctx context.Context, client *mongo.Client, database string, networkCollection string, serviceName string) ([]bson.M, error) {
-	db := client.Database(database)
-	trafficCollection := db.Collection(networkCollection)
+var (
+	// ErrCollectionNotFound is returned when a query names a collection that does not exist.
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrServiceNotFound is returned when no service document matches the requested name.
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrAmbiguousService is returned when a service name without a
+	// namespace matches services in more than one namespace.
+	ErrAmbiguousService = errors.New("service name is ambiguous")
+)
+
+// Collections names the database holding the traffic data and the network
+// and service inventory collections inside it.
+type Collections struct {
+	Database          string
+	NetworkCollection string
+	ServiceCollection string
+}
+
+// TrafficQuery describes which service's traffic to fetch and where the
+// network and service inventory data live.
+type TrafficQuery struct {
+	Collections
+	ServiceName string
+	// Namespace picks ServiceName's namespace. It may be empty when the
+	// name is unique in the inventory.
+	Namespace string
+	// Address selects the flows to or from an address range instead of a
+	// service. It is used when ServiceName is empty.
+	Address netip.Prefix
+	// From and To bound the flows' observed_at time to [From, To). A zero
+	// value leaves that end of the range open.
+	From   time.Time
+	To     time.Time
+	Filter TrafficFilter
+	Page   Page
+}
+
+// FlowQuery selects the flows summarized into the service graph and scanned
+// for unknown endpoints.
+type FlowQuery struct {
+	Collections
+	// Namespace keeps only flows with a service from that namespace on
+	// either end. Empty keeps every flow.
+	Namespace string
+}
+
+// namespaceFilter is the TrafficFilter holding the query's namespace condition.
+func (q FlowQuery) namespaceFilter() TrafficFilter {
+	return TrafficFilter{Namespace: q.Namespace}
+}
 
-	serviceIP, err := getServiceIPByName(ctx, db, serviceName)
+func AggregateTrafficWithService(ctx context.Context, client *mongo.Client, query TrafficQuery) (*TrafficPage, error) {
+	db := client.Database(query.Database)
+	match, subject, err := trafficSelection(ctx, db, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get service IP: %w", err)
+		return nil, err
 	}
 
-	pipeline := mongo.Pipeline{
-		// Step 1: Lookup to join network traffic with service data
-		bson.D{
-			{Key: "$lookup", Value: bson.D{
-				//testCollectionA stores service data and testCollectionB stores network traffic data
-				{Key: "from", Value: "testcollectionA"},
-				{Key: "let", Value: bson.D{
-					{Key: "source_ip", Value: "$source_ip"},
-					{Key: "destination_ip", Value: "$destination_ip"},
-				}},
-				{Key: "pipeline", Value: bson.A{
-					bson.D{
-						{Key: "$match", Value: bson.D{
-							{Key: "$or", Value: bson.A{
-								bson.D{{Key: "ip_address", Value: "$$source_ip"}},
-								bson.D{{Key: "ip_address", Value: "$$destination_ip"}},
-							}},
-						}},
-					},
-					bson.D{
-						{Key: "$project", Value: bson.D{
-							{Key: "name", Value: 1},           // Service name
-							{Key: "ip_address", Value: 1},     // IP address of service
-							{Key: "listening_port", Value: 1}, // Port of service
-						}},
-					},
+	// Step 2: Sort on a stored field and cut out the requested page, so only
+	// the page's rows are joined with the service inventory
+	pageStages, err := paginationStages(query.Page)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: match}}}
+	pipeline = append(pipeline, pageStages...)
+	pipeline = append(pipeline, enrichmentStages(query.ServiceCollection, subject)...)
+
+	collection := db.Collection(query.NetworkCollection)
+	total, err := collection.CountDocuments(ctx, match)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count traffic: %w", err)
+	}
+
+	// Execute the aggregation pipeline
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate data: %w", err)
+	}
+	var rows []pageRow
+	err = decodeEach(ctx, cursor, func(row pageRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return buildPage(query.Page, total, rows), nil
+}
+
+// StreamTrafficWithService runs the same query as AggregateTrafficWithService
+// without cutting it into pages, handing each row to fn as soon as it is read
+// from the cursor. The query's sort order is honoured; its limit and
+// continuation token are not. Rows that fail to decode are skipped.
+// Iteration stops at the first error from fn or when ctx is cancelled.
+func StreamTrafficWithService(ctx context.Context, client *mongo.Client, query TrafficQuery, fn func(ServiceTraffic) error) error {
+	db := client.Database(query.Database)
+	match, subject, err := trafficSelection(ctx, db, query)
+	if err != nil {
+		return err
+	}
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: match}}}
+	pipeline = append(pipeline, sortStages(query.Page)...)
+	pipeline = append(pipeline, enrichmentStages(query.ServiceCollection, subject)...)
+
+	// An export sorts every matching flow, which can exceed the memory a
+	// blocking $sort may use, so let it spill to disk.
+	cursor, err := db.Collection(query.NetworkCollection).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to aggregate data: %w", err)
+	}
+	return decodeEach(ctx, cursor, fn)
+}
+
+// trafficSelection builds the $match selecting a traffic query's flows: the
+// ones that start or end at the subject and pass the filter. A namespace
+// filter is resolved to its services' addresses first, so every condition
+// is on stored fields.
+func trafficSelection(ctx context.Context, db *mongo.Database, query TrafficQuery) (bson.D, trafficSubject, error) {
+	if err := ensureCollectionsExist(ctx, db, query.NetworkCollection, query.ServiceCollection); err != nil {
+		return nil, trafficSubject{}, err
+	}
+
+	subject, err := querySubject(ctx, db, query)
+	if err != nil {
+		return nil, trafficSubject{}, err
+	}
+
+	filter := query.Filter
+	if filter.Namespace != "" {
+		ips, err := namespaceIPs(ctx, db.Collection(query.ServiceCollection), filter.Namespace)
+		if err != nil {
+			return nil, trafficSubject{}, err
+		}
+		filter.namespaceIPs = append(ips, filter.namespaceIPs...)
+	}
+
+	// Step 1: Keep only the flows that start or end at the subject
+	return trafficMatch(subject, filter, query.From, query.To), subject, nil
+}
+
+// enrichmentStages attach the service on each side of the selected flows
+// and project the fields a traffic row returns.
+func enrichmentStages(serviceCollection string, subject trafficSubject) []bson.D {
+	stages := serviceLookupStages(serviceCollection)
+	return append(stages, bson.D{
+		{Key: "$project", Value: bson.D{
+			{Key: "source_ip", Value: 1},
+			{Key: "source_port", Value: 1},
+			{Key: "destination_ip", Value: 1},
+			{Key: "destination_port", Value: 1},
+			{Key: "status", Value: 1},
+			{Key: "protocol", Value: 1},
+			{Key: "observed_at", Value: 1},
+			{Key: "duration_ms", Value: 1},
+			{Key: "bytes", Value: 1},
+			{Key: "packets", Value: 1},
+			{Key: "source_service", Value: 1},
+			{Key: "destination_service", Value: 1},
+			{Key: "sort_key", Value: 1},
+			{Key: "direction", Value: bson.D{
+				{Key: "$cond", Value: bson.A{
+					subject.hasExpr("$destination_ip"),
+					DirectionInbound,
+					DirectionOutbound,
 				}},
-				{Key: "as", Value: "service_info"},
 			}},
-		},
+		}},
+	})
+}
 
-		// Step 2: Unwind the result from the lookup to handle multiple matches
+// namespaceIPs returns the addresses of the live services in namespace,
+// the ones the service lookup can attribute a flow to.
+func namespaceIPs(ctx context.Context, collection *mongo.Collection, namespace string) ([]string, error) {
+	cursor, err := collection.Find(ctx,
+		bson.D{{Key: "namespace", Value: namespace}, {Key: "removed_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		options.Find().SetProjection(bson.D{{Key: "ip_addresses", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find services in namespace %s: %w", namespace, err)
+	}
+	var ips []string
+	err = decodeEach(ctx, cursor, func(svc service.ServiceData) error {
+		ips = append(ips, svc.IPs...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode services in namespace %s: %w", namespace, err)
+	}
+	return ips, nil
+}
+
+// querySubject resolves what a traffic query selects flows by: the named
+// service's addresses or, without a service name, the query's address range.
+func querySubject(ctx context.Context, db *mongo.Database, query TrafficQuery) (trafficSubject, error) {
+	if query.ServiceName == "" {
+		if !query.Address.IsValid() {
+			return trafficSubject{}, errors.New("traffic query needs a service name or an address range")
+		}
+		return trafficSubject{prefixes: []netip.Prefix{query.Address}}, nil
+	}
+
+	svc, err := findServiceByName(ctx, db.Collection(query.ServiceCollection), query.Namespace, query.ServiceName)
+	if err != nil {
+		return trafficSubject{}, fmt.Errorf("failed to get service IP: %w", err)
+	}
+	if len(svc.IPs) == 0 {
+		return trafficSubject{}, fmt.Errorf("service %s has no IP addresses", query.ServiceName)
+	}
+	return trafficSubject{ips: svc.IPs}, nil
+}
+
+// SummarizeFlows joins the network collection with the service inventory
+// and counts the flows between each pair of endpoints, keeping only the
+// flows in the query's namespace when it has one.
+func SummarizeFlows(ctx context.Context, client *mongo.Client, query FlowQuery) ([]FlowSummary, error) {
+	collections := query.Collections
+	db := client.Database(collections.Database)
+	if err := ensureCollectionsExist(ctx, db, collections.NetworkCollection, collections.ServiceCollection); err != nil {
+		return nil, err
+	}
+
+	// endpoint keys a side of the flow by service name and namespace,
+	// falling back to the IP address when no service was found for it.
+	endpoint := func(side string) bson.D {
+		return bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$" + side + "_service"}}, "missing"}}},
+			bson.D{{Key: "ip", Value: "$" + side + "_ip"}},
+			bson.D{
+				{Key: "service", Value: "$" + side + "_service.name"},
+				{Key: "namespace", Value: "$" + side + "_service.namespace"},
+			},
+		}}}
+	}
+
+	pipeline := mongo.Pipeline(serviceLookupStages(collections.ServiceCollection))
+	if stage, ok := query.namespaceFilter().serviceMatchStage(); ok {
+		pipeline = append(pipeline, stage)
+	}
+	pipeline = append(pipeline,
 		bson.D{
-			{Key: "$match", Value: bson.D{
-				{Key: "$or", Value: bson.A{
-					bson.D{{Key: "source_ip", Value: serviceIP}},
-					bson.D{{Key: "destination_ip", Value: serviceIP}},
+			{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{
+					{Key: "source", Value: endpoint("source")},
+					{Key: "destination", Value: endpoint("destination")},
 				}},
+				{Key: "flows", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "statuses", Value: bson.D{{Key: "$addToSet", Value: "$status"}}},
+				{Key: "first_seen", Value: bson.D{{Key: "$min", Value: "$observed_at"}}},
+				{Key: "last_seen", Value: bson.D{{Key: "$max", Value: "$observed_at"}}},
 			}},
 		},
-
-		// Step 3: Project stage to select the fields we want to return
 		bson.D{
 			{Key: "$project", Value: bson.D{
-				{Key: "source_ip", Value: 1},
-				{Key: "source_port", Value: 1},
-				{Key: "destination_ip", Value: 1},
-				{Key: "destination_port", Value: 1},
-				{Key: "status", Value: 1},
-				{Key: "service_name", Value: "$service_info.name"},
-				{Key: "service_ip", Value: "$service_info.ip_address"},
-				{Key: "service_port", Value: "$service_info.listening_port"},
+				{Key: "_id", Value: 0},
+				{Key: "source", Value: "$_id.source"},
+				{Key: "destination", Value: "$_id.destination"},
+				{Key: "flows", Value: 1},
+				{Key: "statuses", Value: 1},
+				{Key: "first_seen", Value: 1},
+				{Key: "last_seen", Value: 1},
 			}},
 		},
-	}
+	)
 
-	// Execute the aggregation pipeline
-	cursor, err := trafficCollection.Aggregate(ctx, pipeline)
+	cursor, err := db.Collection(collections.NetworkCollection).Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, fmt.Errorf("failed to aggregate data: %w", err)
 	}
-	defer cursor.Close(ctx)
 
-	// Collect the results into a slice of documents
-	var results []bson.M
-	for cursor.Next(ctx) {
-		var result bson.M
-		if err := cursor.Decode(&result); err != nil {
-			log.Printf("failed to decode result: %v", err)
+	var results []FlowSummary
+	err = decodeEach(ctx, cursor, func(summary FlowSummary) error {
+		results = append(results, summary)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode flow summaries: %w", err)
+	}
+	return results, nil
+}
+
+// serviceFlowFilter matches the flows that start or end at one of the given IPs.
+func serviceFlowFilter(ips []string) bson.D {
+	return bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "source_ip", Value: bson.D{{Key: "$in", Value: ips}}}},
+			bson.D{{Key: "destination_ip", Value: bson.D{{Key: "$in", Value: ips}}}},
+		}},
+	}
+}
+
+// timeRangeFilter returns the condition matching times in [from, to), or nil
+// when both ends of the range are open.
+func timeRangeFilter(from, to time.Time) bson.D {
+	var filter bson.D
+	if !from.IsZero() {
+		filter = append(filter, bson.E{Key: "$gte", Value: from})
+	}
+	if !to.IsZero() {
+		filter = append(filter, bson.E{Key: "$lt", Value: to})
+	}
+	return filter
+}
+
+// EnsureTrafficIndexes creates the indexes that back time-range, address
+// and address range queries on a network collection. It is safe to call
+// repeatedly.
+func (m *MongoClient) EnsureTrafficIndexes(ctx context.Context, database, collection string) error {
+	_, err := m.client.Database(database).Collection(collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "observed_at", Value: 1}}},
+		{Keys: bson.D{{Key: "source_ip", Value: 1}, {Key: "observed_at", Value: 1}}},
+		{Keys: bson.D{{Key: "destination_ip", Value: 1}, {Key: "observed_at", Value: 1}}},
+		{Keys: bson.D{{Key: "source_ip_num", Value: 1}, {Key: "observed_at", Value: 1}}},
+		{Keys: bson.D{{Key: "destination_ip_num", Value: 1}, {Key: "observed_at", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create traffic indexes: %w", err)
+	}
+	return nil
+}
+
+// EnsureServiceIndexes creates the indexes that back service lookups by name
+// and by address. It is safe to call repeatedly.
+func (m *MongoClient) EnsureServiceIndexes(ctx context.Context, database, collection string) error {
+	_, err := m.client.Database(database).Collection(collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "namespace", Value: 1}}},
+		{Keys: bson.D{{Key: "ip_addresses", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service indexes: %w", err)
+	}
+	return nil
+}
+
+// EnsureCollections creates whichever of the named collections do not exist yet.
+func (m *MongoClient) EnsureCollections(ctx context.Context, database string, collections ...string) error {
+	db := m.client.Database(database)
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$in": collections}})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, name := range collections {
+		if existing[name] {
 			continue
 		}
-		results = append(results, result)
+		if err := db.CreateCollection(ctx, name); err != nil {
+			return fmt.Errorf("failed to create collection %s.%s: %w", database, name, err)
+		}
 	}
+	return nil
+}
 
-	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("cursor iteration error: %w", err)
+// DropCollections drops the named collections along with their data and indexes.
+func (m *MongoClient) DropCollections(ctx context.Context, database string, collections ...string) error {
+	db := m.client.Database(database)
+	for _, name := range collections {
+		if err := db.Collection(name).Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop collection %s.%s: %w", database, name, err)
+		}
 	}
+	return nil
+}
 
-	return results, nil
+// serviceLookupStages joins each flow with the service inventory, setting
+// source_service and destination_service to the service listening on that
+// side's IP along with the named port matching that side's port. A side with
+// no matching live service is left unset.
+func serviceLookupStages(serviceCollection string) []bson.D {
+	var stages []bson.D
+	for _, side := range []string{"source", "destination"} {
+		field := side + "_service"
+		stages = append(stages,
+			liveServiceLookup(serviceCollection, side+"_ip", field),
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: field, Value: bson.D{{Key: "$let", Value: bson.D{
+						{Key: "vars", Value: bson.D{
+							{Key: "svc", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{"$" + field, 0}}}},
+						}},
+						{Key: "in", Value: bson.D{{Key: "$cond", Value: bson.A{
+							bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$$svc"}}, "missing"}}},
+							"$$REMOVE",
+							bson.D{
+								{Key: "name", Value: "$$svc.name"},
+								{Key: "namespace", Value: "$$svc.namespace"},
+								{Key: "ip_address", Value: "$" + side + "_ip"},
+								{Key: "port", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{
+									bson.D{{Key: "$filter", Value: bson.D{
+										{Key: "input", Value: servicePortsExpr(side)},
+										{Key: "as", Value: "port"},
+										{Key: "cond", Value: bson.D{{Key: "$eq", Value: bson.A{"$$port.port", "$" + side + "_port"}}}},
+									}}},
+									0,
+								}}}},
+							},
+						}}}},
+					}}}},
+				}},
+			},
+		)
+	}
+	return stages
 }
 
-func getServiceIPByName(ctx context.Context, db *mongo.Database, serviceName string) (serviceIP string, err error) {
-	serviceCollection := db.Collection("testcollectionA")
+// servicePortsExpr picks the ports to match a side's port against, from the
+// service bound to $$svc: the ports of the endpoint at that side's IP when it
+// is one, and the service ports otherwise, mirroring ServiceData.PortFor.
+func servicePortsExpr(side string) bson.D {
+	return bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{
+			{Key: "endpoint", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{
+				bson.D{{Key: "$filter", Value: bson.D{
+					{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$svc.endpoints", bson.A{}}}}},
+					{Key: "as", Value: "candidate"},
+					{Key: "cond", Value: bson.D{{Key: "$eq", Value: bson.A{"$$candidate.ip", "$" + side + "_ip"}}}},
+				}}},
+				0,
+			}}}},
+		}},
+		{Key: "in", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+			"$$endpoint.ports",
+			bson.D{{Key: "$ifNull", Value: bson.A{"$$svc.ports", bson.A{}}}},
+		}}}},
+	}}}
+}
+
+// liveServiceLookup is the $lookup joining the services whose ip_addresses
+// hold the address in localField into the array as. Services marked as
+// removed from their source are left out, so an address reused by a new
+// service is never attributed to the one it replaced.
+func liveServiceLookup(serviceCollection, localField, as string) bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: serviceCollection},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: "ip_addresses"},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{{Key: "removed_at", Value: bson.D{{Key: "$exists", Value: false}}}}}},
+		}},
+		{Key: "as", Value: as},
+	}}}
+}
+
+// findServiceByName returns the live service document with the given name
+// in namespace. An empty namespace matches any, but then the name must not
+// be used in more than one namespace. Services marked as removed are
+// ignored.
+func findServiceByName(ctx context.Context, serviceCollection *mongo.Collection, namespace, serviceName string) (*service.ServiceData, error) {
+	id := service.ServiceID{Namespace: namespace, Name: serviceName}
+	filter := bson.D{
+		{Key: "name", Value: serviceName},
+		{Key: "removed_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	if namespace != "" {
+		filter = append(filter, bson.E{Key: "namespace", Value: namespace})
+	}
+
+	var services []service.ServiceData
+	cursor, err := serviceCollection.Find(ctx, filter, options.Find().SetLimit(2))
+	if err == nil {
+		err = cursor.All(ctx, &services)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service collection: %w", err)
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, id)
+	}
+	if len(services) > 1 && services[0].Namespace != services[1].Namespace {
+		return nil, fmt.Errorf("%w: %s exists in several namespaces", ErrAmbiguousService, serviceName)
+	}
+	return &services[0], nil
+}
 
-	// Define the filter for the service name
-	filter := bson.M{
-		"name": serviceName,
+// namespaceValue is the value a service's namespace is stored and matched
+// as: null for services without one, so documents missing the field match.
+func namespaceValue(namespace string) interface{} {
+	if namespace == "" {
+		return nil
 	}
+	return namespace
+}
 
-	cursor, err := serviceCollection.Find(ctx, filter)
+// ensureCollectionsExist checks that every named collection exists in db.
+func ensureCollectionsExist(ctx context.Context, db *mongo.Database, collections ...string) error {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$in": collections}})
 	if err != nil {
-		return "", fmt.Errorf("failed to query service collection: %w", err)
+		return fmt.Errorf("failed to list collections: %w", err)
 	}
-	defer cursor.Close(ctx)
 
-	// Iterate through the cursor and get the result
-	for cursor.Next(ctx) {
-		var result bson.M
-		if err := cursor.Decode(&result); err != nil {
-			return "", fmt.Errorf("failed to decode service result: %w", err)
-		}
-		serviceIP, ok := result["ip_address"].(string)
-		if !ok {
-			return "", fmt.Errorf("invalid IP address format for service %s", serviceName)
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, name := range collections {
+		if !existing[name] {
+			return fmt.Errorf("%w: %s.%s", ErrCollectionNotFound, db.Name(), name)
 		}
-		return serviceIP, nil
 	}
+	return nil
+}
 
-	if err := cursor.Err(); err != nil {
-		return "", fmt.Errorf("cursor iteration error: %w", err)
-	}
-	return "", fmt.Errorf("service not found: %s", serviceName)
+// AggregateTrafficWithService runs the traffic aggregation on the shared client connection pool.
+func (m *MongoClient) AggregateTrafficWithService(ctx context.Context, query TrafficQuery) (*TrafficPage, error) {
+	return AggregateTrafficWithService(ctx, m.client, query)
+}
+
+// SummarizeFlows counts the flows between each pair of endpoints using the shared client.
+func (m *MongoClient) SummarizeFlows(ctx context.Context, query FlowQuery) ([]FlowSummary, error) {
+	return SummarizeFlows(ctx, m.client, query)
+}
+
+// StreamTrafficWithService streams the service's traffic using the shared client.
+func (m *MongoClient) StreamTrafficWithService(ctx context.Context, query TrafficQuery, fn func(ServiceTraffic) error) error {
+	return StreamTrafficWithService(ctx, m.client, query, fn)
 }
@@ -2,13 +2,15 @@ package database
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"time"
 
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -20,6 +22,8 @@ type MongoClient struct {
 	client *mongo.Client
 }
 
+var _ Repository = (*MongoClient)(nil)
+
 // Disconnect cleans up the MongoDB connection.
 func (mc *MongoClient) Disconnect() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -38,8 +42,11 @@ func (mc *MongoClient) GetDatabase(name string) *mongo.Database {
 
 // CreateMongoClient creates and returns a MongoDB client connected to the database.
 func CreateMongoClient() (*MongoClient, error) {
-	mongoURI := os.Getenv("MONGO_URI")
+	return NewMongoClient(os.Getenv("MONGO_URI"))
+}
 
+// NewMongoClient creates and returns a MongoDB client connected to the given URI.
+func NewMongoClient(mongoURI string) (*MongoClient, error) {
 	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MongoDB client: %w", err)
@@ -63,10 +70,18 @@ func CreateMongoClient() (*MongoClient, error) {
 }
 
 // InsertAPIData inserts service or network api data into a MongoDB collection.
+// Network traffic records are stored in their trafficDocument form, as
+// InsertTraffic stores them.
 func (m *MongoClient) InsertAPIData(database, collection string, serviceData interface{}) error {
 	db := m.client.Database(database)
 	coll := db.Collection(collection)
 
+	switch traffic := serviceData.(type) {
+	case network.NetworkTraffic:
+		serviceData = newTrafficDocument(traffic)
+	case *network.NetworkTraffic:
+		serviceData = newTrafficDocument(*traffic)
+	}
 	_, err := coll.InsertOne(context.Background(), serviceData)
 	if err != nil {
 		return fmt.Errorf("failed to insert service data: %v", err)
@@ -102,34 +117,266 @@ func (m *MongoClient) GetAllDataFromMongo(database, collection string) ([]interf
 	return results, nil
 }
 
-// InsertJSONData parses JSON from a file and inserts it into the MongoDB collection
-func (m *MongoClient) InsertJSONData(dbName, collectionName, filePath string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// trafficDocument is the stored form of a network traffic record. Besides
+// the record it holds each address as a 32-bit number, -1 for addresses
+// that are not IPv4, so CIDR filters become range scans on an index, and
+// the status's severity so status sorts need no computed key.
+type trafficDocument struct {
+	network.NetworkTraffic `bson:",inline"`
+	SourceIPNumber         int64 `bson:"source_ip_num"`
+	DestinationIPNumber    int64 `bson:"destination_ip_num"`
+	Severity               int   `bson:"severity"`
+}
+
+func newTrafficDocument(traffic network.NetworkTraffic) trafficDocument {
+	return trafficDocument{
+		NetworkTraffic:      traffic,
+		SourceIPNumber:      ipv4Number(traffic.SourceIP),
+		DestinationIPNumber: ipv4Number(traffic.DestinationIP),
+		Severity:            traffic.Status.Severity(),
+	}
+}
+
+// InsertTraffic inserts a single network traffic record.
+func (m *MongoClient) InsertTraffic(ctx context.Context, database, collection string, traffic network.NetworkTraffic) error {
+	traffic.Normalize()
+	if err := traffic.Validate(); err != nil {
+		return fmt.Errorf("invalid traffic record: %w", err)
+	}
+	if _, err := m.client.Database(database).Collection(collection).InsertOne(ctx, newTrafficDocument(traffic)); err != nil {
+		return fmt.Errorf("failed to insert traffic data: %w", err)
+	}
+	return nil
+}
+
+// InsertTrafficBatch validates records and writes the valid ones with a
+// single unordered bulk insert, so one bad record does not stop the rest
+// from being written. Records that fail validation or that the server
+// rejects are reported by their index in records; the error is only set
+// when the batch as a whole failed.
+func (m *MongoClient) InsertTrafficBatch(ctx context.Context, database, collection string, records []network.NetworkTraffic) ([]RecordError, error) {
+	valid, positions, failures := validateBatch(records)
+	if len(valid) == 0 {
+		return failures, nil
+	}
+	docs := make([]interface{}, len(valid))
+	for i, record := range valid {
+		docs[i] = newTrafficDocument(record)
+	}
 
-	jsonData, err := ioutil.ReadFile(filePath)
+	_, err := m.client.Database(database).Collection(collection).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failures = append(failures, RecordError{Index: positions[writeErr.Index], Error: writeErr.Message})
+		}
+		return failures, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read JSON file: %v", err)
+		return nil, fmt.Errorf("failed to insert traffic data: %w", err)
+	}
+	return failures, nil
+}
+
+// validateBatch splits records into the valid ones, normalized and along
+// with their index in records, and the errors for the rest.
+func validateBatch(records []network.NetworkTraffic) ([]network.NetworkTraffic, []int, []RecordError) {
+	var valid []network.NetworkTraffic
+	var positions []int
+	var failures []RecordError
+	for i, record := range records {
+		record.Normalize()
+		if err := record.Validate(); err != nil {
+			failures = append(failures, RecordError{Index: i, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, record)
+		positions = append(positions, i)
+	}
+	return valid, positions, failures
+}
+
+// FindTraffic returns every network traffic record in the collection.
+func (m *MongoClient) FindTraffic(ctx context.Context, database, collection string) ([]network.NetworkTraffic, error) {
+	var results []network.NetworkTraffic
+	if err := findAll(ctx, m.client.Database(database).Collection(collection), bson.D{}, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// InsertService inserts a single service inventory record.
+func (m *MongoClient) InsertService(ctx context.Context, database, collection string, svc service.ServiceData) error {
+	if _, err := m.client.Database(database).Collection(collection).InsertOne(ctx, svc); err != nil {
+		return fmt.Errorf("failed to insert service data: %w", err)
 	}
+	return nil
+}
+
+// FindServices returns every service in the inventory collection.
+func (m *MongoClient) FindServices(ctx context.Context, database, collection string) ([]service.ServiceData, error) {
+	var results []service.ServiceData
+	if err := findAll(ctx, m.client.Database(database).Collection(collection), bson.D{}, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// FindServiceByName returns the service with the given name in namespace,
+// or ErrServiceNotFound. An empty namespace matches any namespace, and
+// ErrAmbiguousService is returned when the name is used in several.
+func (m *MongoClient) FindServiceByName(ctx context.Context, database, collection, namespace, name string) (*service.ServiceData, error) {
+	return findServiceByName(ctx, m.client.Database(database).Collection(collection), namespace, name)
+}
 
-	// Parse the JSON data into a slice of bson.M
-	var documents []bson.M
-	err = json.Unmarshal(jsonData, &documents)
+// UpsertService replaces the service with the same name, namespace and
+// source, or inserts it if there is none.
+func (m *MongoClient) UpsertService(ctx context.Context, database, collection string, svc service.ServiceData) error {
+	filter := bson.D{
+		{Key: "name", Value: svc.Name},
+		{Key: "namespace", Value: namespaceValue(svc.Namespace)},
+		{Key: "source", Value: svc.Source},
+	}
+	_, err := m.client.Database(database).Collection(collection).ReplaceOne(ctx, filter, svc, options.Replace().SetUpsert(true))
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON data: %v", err)
+		return fmt.Errorf("failed to upsert service data: %w", err)
+	}
+	return nil
+}
+
+// MarkServicesRemoved sets removed_at on every service from source inside
+// scope that is not listed in keep and not already marked, and returns how
+// many it marked. The candidates are read first so the scope's selectors
+// can be matched against their stored labels.
+func (m *MongoClient) MarkServicesRemoved(ctx context.Context, database, collection, source string, scope service.Scope, keep []service.ServiceID, removedAt time.Time) (int, error) {
+	coll := m.client.Database(database).Collection(collection)
+	filter := bson.D{
+		{Key: "source", Value: source},
+		{Key: "removed_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	if scope.Namespace != "" {
+		filter = append(filter, bson.E{Key: "namespace", Value: scope.Namespace})
+	}
+	if len(keep) > 0 {
+		kept := make(bson.A, 0, len(keep))
+		for _, id := range keep {
+			kept = append(kept, bson.D{{Key: "name", Value: id.Name}, {Key: "namespace", Value: namespaceValue(id.Namespace)}})
+		}
+		filter = append(filter, bson.E{Key: "$nor", Value: kept})
+	}
+
+	var candidates []storedService
+	if err := findAll(ctx, coll, filter, &candidates); err != nil {
+		return 0, fmt.Errorf("failed to find removed services: %w", err)
+	}
+	var ids bson.A
+	for _, candidate := range candidates {
+		if scope.Contains(candidate.ServiceData) {
+			ids = append(ids, candidate.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
 	}
 
-	// Convert documents (which is of type []bson.M) into []interface{}
-	var interfaces []interface{}
-	for _, doc := range documents {
-		interfaces = append(interfaces, doc)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "removed_at", Value: removedAt}}}}
+	result, err := coll.UpdateMany(ctx, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		{Key: "removed_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark removed services: %w", err)
 	}
+	return int(result.ModifiedCount), nil
+}
 
-	collection := m.client.Database(dbName).Collection(collectionName)
+// storedService is a service document along with its _id.
+type storedService struct {
+	ID                  interface{} `bson:"_id"`
+	service.ServiceData `bson:",inline"`
+}
 
-	_, err = collection.InsertMany(ctx, interfaces)
+// findAll decodes every document matching filter into results, skipping
+// the ones that fail to decode as decodeEach does.
+func findAll[T any](ctx context.Context, coll *mongo.Collection, filter interface{}, results *[]T) error {
+	cursor, err := coll.Find(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to insert data into MongoDB: %v", err)
+		return fmt.Errorf("failed to fetch data: %w", err)
+	}
+	return decodeEach(ctx, cursor, func(doc T) error {
+		*results = append(*results, doc)
+		return nil
+	})
+}
+
+// decodeEach decodes the documents of cursor one at a time and hands each
+// to fn, stopping at the first error from fn. A document that fails to
+// decode, such as one stored with a malformed field before validation
+// existed, is logged and skipped rather than failing the whole read.
+func decodeEach[T any](ctx context.Context, cursor *mongo.Cursor, fn func(T) error) error {
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc T
+		if err := decodeDocument(cursor.Current, &doc); err != nil {
+			continue
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor iteration error: %w", err)
+	}
+	return nil
+}
+
+// decodeDocument decodes doc into result, logging the document's _id when
+// it cannot be decoded. A traffic row stored with an unknown status decodes
+// but cannot be written out as JSON, so it is skipped the same way.
+func decodeDocument(doc bson.Raw, result interface{}) error {
+	if err := bson.Unmarshal(doc, result); err != nil {
+		log.Printf("Skipping document that failed to decode: %v (_id %v)", err, doc.Lookup("_id"))
+		return err
+	}
+	var status *network.TrafficStatus
+	switch row := result.(type) {
+	case *network.NetworkTraffic:
+		status = &row.Status
+	case *ServiceTraffic:
+		status = &row.Status
+	case *pageRow:
+		status = &row.Status
+	}
+	if status != nil && !status.IsValid() {
+		err := fmt.Errorf("%w %q", network.ErrInvalidStatus, string(*status))
+		log.Printf("Skipping document that failed to decode: %v (_id %v)", err, doc.Lookup("_id"))
+		return err
 	}
 	return nil
 }
+
+// readExtJSONFile reads every document from a file holding a JSON array of
+// documents, or one document per line, the same way InsertJSONData does.
+func readExtJSONFile(filePath string) ([]interface{}, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := newDocumentReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var documents []interface{}
+	for {
+		doc, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+}
@@ -2,9 +2,17 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net/netip"
 	"testing"
+	"time"
 
+	"example.com/m/internal/network"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
@@ -27,3 +35,64 @@ func TestMongoMock(t *testing.T) {
 		log.Fatalf("Error disconnecting mock MongoDB client: %v", err)
 	}
 }
+
+func TestTrafficMatch(t *testing.T) {
+	subject := trafficSubject{prefixes: []netip.Prefix{netip.MustParsePrefix("10.128.0.0/16")}}
+	match := trafficMatch(subject, TrafficFilter{
+		Direction:   DirectionInbound,
+		SourceCIDRs: []netip.Prefix{netip.MustParsePrefix("103.0.0.0/8"), netip.MustParsePrefix("172.135.0.0/16")},
+	}, time.Time{}, time.Time{})
+
+	data, err := bson.MarshalExtJSON(match, false, false)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "$expr", "range filters must not need a collection scan")
+	assert.Contains(t, string(data), `{"destination_ip_num":{"$gte":176160768,"$lte":176226303}}`)
+	assert.Contains(t, string(data), `{"source_ip_num":{"$gte":1728053248,"$lte":1744830463}}`)
+}
+
+func TestTrafficDocument(t *testing.T) {
+	data, err := bson.Marshal(newTrafficDocument(network.NetworkTraffic{
+		SourceIP: "10.128.72.69", DestinationIP: "fd00::1", Status: network.StatusOK,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(176179269), bson.Raw(data).Lookup("source_ip_num").Int64())
+	assert.Equal(t, int64(-1), bson.Raw(data).Lookup("destination_ip_num").Int64())
+	assert.Equal(t, int32(1), bson.Raw(data).Lookup("severity").Int32())
+}
+
+func TestPaginationStages(t *testing.T) {
+	page := Page{Limit: 2, Sort: SortByStatus, Descending: true}
+	page.After = encodePageToken(page, 2, primitive.NewObjectID())
+	stages, err := paginationStages(page)
+	assert.NoError(t, err)
+
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "stages", Value: stages}}, false, false)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "$expr", "pages must be cut on stored fields")
+	assert.Contains(t, string(data), `{"$sort":{"severity":-1,"_id":-1}}`)
+	assert.Contains(t, string(data), `{"severity":{"$lt":2}}`)
+	assert.Contains(t, string(data), `{"$limit":3}`)
+}
+
+func TestDecodeEach(t *testing.T) {
+	cursor, err := mongo.NewCursorFromDocuments([]interface{}{
+		bson.D{{Key: "source_ip", Value: "10.0.0.1"}, {Key: "status", Value: "OK"}},
+		bson.D{{Key: "source_ip", Value: "10.0.0.2"}, {Key: "status", Value: 3}},
+		bson.D{{Key: "source_ip", Value: "10.0.0.3"}},
+	}, nil, nil)
+	assert.NoError(t, err)
+
+	var rows []ServiceTraffic
+	err = decodeEach(context.Background(), cursor, func(row ServiceTraffic) error {
+		rows = append(rows, row)
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1, "undecodable rows and rows without a status are skipped") {
+		assert.Equal(t, "10.0.0.1", rows[0].SourceIP)
+	}
+
+	data, err := json.Marshal(rows[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"status":"OK"`)
+}
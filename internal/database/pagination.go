@@ -0,0 +1,325 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"example.com/m/internal/network"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultPageSize is the number of rows returned when a query sets no limit.
+	DefaultPageSize = 100
+	// MaxPageSize caps the number of rows a single page may hold.
+	MaxPageSize = 1000
+)
+
+// ErrInvalidToken is returned when a continuation token cannot be decoded or
+// was issued for a different sort order.
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+// SortField selects the order traffic rows are returned in.
+type SortField string
+
+const (
+	// SortDefault orders rows by insertion order.
+	SortDefault         SortField = ""
+	SortByStatus        SortField = "status"
+	SortBySourceIP      SortField = "source_ip"
+	SortByDestinationIP SortField = "destination_ip"
+	SortBySourcePort    SortField = "source_port"
+	SortByDestPort      SortField = "destination_port"
+)
+
+// ParseSortField validates a sort field name from a request.
+func ParseSortField(s string) (SortField, error) {
+	switch field := SortField(s); field {
+	case SortDefault, SortByStatus, SortBySourceIP, SortByDestinationIP, SortBySourcePort, SortByDestPort:
+		return field, nil
+	default:
+		return "", fmt.Errorf("unsupported sort field %q", s)
+	}
+}
+
+// Page controls how much of a result set is returned and in which order.
+type Page struct {
+	Limit      int
+	Sort       SortField
+	Descending bool
+	// After is the continuation token from the previous page, if any.
+	After string
+}
+
+// size returns the effective page size.
+func (p Page) size() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageSize
+	case p.Limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return p.Limit
+	}
+}
+
+// TrafficPage is one page of enriched traffic rows.
+type TrafficPage struct {
+	Items []ServiceTraffic `json:"items"`
+	// Total counts every row matching the query, across all pages.
+	Total int64 `json:"total"`
+	// ContinuationToken fetches the next page; it is empty on the last page.
+	ContinuationToken string `json:"continuationToken,omitempty"`
+}
+
+// pageToken is the decoded form of a continuation token: the sort key and ID
+// of the last row on the previous page.
+type pageToken struct {
+	Sort       SortField          `json:"s"`
+	Descending bool               `json:"d"`
+	Key        int64              `json:"k"`
+	ID         primitive.ObjectID `json:"i"`
+}
+
+func encodePageToken(page Page, key int64, id primitive.ObjectID) string {
+	data, _ := json.Marshal(pageToken{Sort: page.Sort, Descending: page.Descending, Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken returns the position the page starts after, or nil for the first page.
+func decodePageToken(page Page) (*pageToken, error) {
+	if page.After == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(page.After)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var token pageToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if token.Sort != page.Sort || token.Descending != page.Descending {
+		return nil, fmt.Errorf("%w: token was issued for a different sort order", ErrInvalidToken)
+	}
+	return &token, nil
+}
+
+// ipv4Pattern matches dotted-quad IPv4 addresses.
+const ipv4Pattern = `^[0-9]{1,3}(\.[0-9]{1,3}){3}$`
+
+// ipv4NumberExpr converts the IPv4 address in the given field path to its
+// 32-bit numeric value so addresses sort and compare numerically. Anything
+// that is not an IPv4 address becomes -1.
+func ipv4NumberExpr(field string) bson.D {
+	return bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$regexMatch", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{field, ""}}}},
+			{Key: "regex", Value: ipv4Pattern},
+		}}},
+		bson.D{{Key: "$reduce", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$split", Value: bson.A{field, "."}}}},
+			{Key: "initialValue", Value: int64(0)},
+			{Key: "in", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$multiply", Value: bson.A{"$$value", 256}}},
+				bson.D{{Key: "$toLong", Value: "$$this"}},
+			}}}},
+		}}},
+		int64(-1),
+	}}}
+}
+
+// ipv4Number is the in-process equivalent of ipv4NumberExpr.
+func ipv4Number(ip string) int64 {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is4() {
+		return -1
+	}
+	b := addr.As4()
+	return int64(b[0])<<24 | int64(b[1])<<16 | int64(b[2])<<8 | int64(b[3])
+}
+
+// sortFieldPath returns the stored traffic document field rows are ordered
+// by ahead of _id, or "" when they are ordered by _id alone. Every sort is
+// on a stored field so pages are cut before flows are joined with services.
+func sortFieldPath(field SortField) string {
+	switch field {
+	case SortByStatus:
+		return "severity"
+	case SortBySourceIP:
+		return "source_ip_num"
+	case SortByDestinationIP:
+		return "destination_ip_num"
+	case SortBySourcePort:
+		return "source_port"
+	case SortByDestPort:
+		return "destination_port"
+	default:
+		return ""
+	}
+}
+
+// severityExpr ranks the status in field the way TrafficStatus.Severity does.
+func severityExpr(field string) bson.D {
+	return bson.D{{Key: "$switch", Value: bson.D{
+		{Key: "branches", Value: bson.A{
+			bson.D{{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{field, network.StatusOK}}}}, {Key: "then", Value: 1}},
+			bson.D{{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{field, network.StatusWarning}}}}, {Key: "then", Value: 2}},
+			bson.D{{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{field, network.StatusCritical}}}}, {Key: "then", Value: 3}},
+		}},
+		{Key: "default", Value: 0},
+	}}}
+}
+
+// sortKey is the in-process equivalent of the stored field sortFieldPath names.
+func sortKey(field SortField, row ServiceTraffic) int64 {
+	switch field {
+	case SortByStatus:
+		return int64(row.Status.Severity())
+	case SortBySourceIP:
+		return ipv4Number(row.SourceIP)
+	case SortByDestinationIP:
+		return ipv4Number(row.DestinationIP)
+	case SortBySourcePort:
+		return int64(row.SourcePort)
+	case SortByDestPort:
+		return int64(row.DestinationPort)
+	default:
+		return 0
+	}
+}
+
+// paginationStages sorts the matched flows on a stored field and cuts out
+// the requested page, one row more than the page size so callers can tell
+// whether a next page exists. They run before the service lookups, so only
+// the page's rows are joined; each row keeps its sort key as sort_key.
+func paginationStages(page Page) ([]bson.D, error) {
+	after, err := decodePageToken(page)
+	if err != nil {
+		return nil, err
+	}
+
+	field := sortFieldPath(page.Sort)
+	compare := "$gt"
+	if page.Descending {
+		compare = "$lt"
+	}
+
+	var stages []bson.D
+	if after != nil {
+		position := bson.D{{Key: "_id", Value: bson.D{{Key: compare, Value: after.ID}}}}
+		if field != "" {
+			position = bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: field, Value: bson.D{{Key: compare, Value: after.Key}}}},
+				bson.D{{Key: field, Value: after.Key}, {Key: "_id", Value: bson.D{{Key: compare, Value: after.ID}}}},
+			}}}
+		}
+		stages = append(stages, bson.D{{Key: "$match", Value: position}})
+	}
+
+	var key interface{} = bson.D{{Key: "$literal", Value: int64(0)}}
+	if field != "" {
+		key = "$" + field
+	}
+	return append(stages,
+		bson.D{{Key: "$sort", Value: sortOrder(page)}},
+		bson.D{{Key: "$limit", Value: page.size() + 1}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "sort_key", Value: key}}}},
+	), nil
+}
+
+// sortStages orders rows for a streamed query, which is not cut into pages.
+func sortStages(page Page) []bson.D {
+	if page.Sort == SortDefault && !page.Descending {
+		return nil
+	}
+	return []bson.D{{{Key: "$sort", Value: sortOrder(page)}}}
+}
+
+// sortOrder is the $sort specification for the page's order.
+func sortOrder(page Page) bson.D {
+	direction := 1
+	if page.Descending {
+		direction = -1
+	}
+	var order bson.D
+	if field := sortFieldPath(page.Sort); field != "" {
+		order = append(order, bson.E{Key: field, Value: direction})
+	}
+	return append(order, bson.E{Key: "_id", Value: direction})
+}
+
+// pageRow is a traffic row carrying the sort key it was ordered by.
+type pageRow struct {
+	ServiceTraffic `bson:",inline"`
+	SortKey        int64 `bson:"sort_key"`
+}
+
+// buildPage trims the look-ahead row and issues the next continuation token.
+func buildPage(page Page, total int64, rows []pageRow) *TrafficPage {
+	result := &TrafficPage{Items: []ServiceTraffic{}, Total: total}
+	size := page.size()
+	if len(rows) > size {
+		last := rows[size-1]
+		result.ContinuationToken = encodePageToken(page, last.SortKey, last.ID)
+		rows = rows[:size]
+	}
+	for _, row := range rows {
+		result.Items = append(result.Items, row.ServiceTraffic)
+	}
+	return result
+}
+
+// paginate applies a page to rows held in memory, mirroring paginationStages
+// and the separate count of matching rows.
+func paginate(page Page, rows []ServiceTraffic) (*TrafficPage, error) {
+	after, err := decodePageToken(page)
+	if err != nil {
+		return nil, err
+	}
+
+	keyed := sortRows(page, rows)
+	start := 0
+	if after != nil {
+		position := pageRow{ServiceTraffic: ServiceTraffic{ID: after.ID}, SortKey: after.Key}
+		start = sort.Search(len(keyed), func(i int) bool {
+			if page.Descending {
+				return rowLess(keyed[i], position)
+			}
+			return rowLess(position, keyed[i])
+		})
+	}
+	end := start + page.size() + 1
+	if end > len(keyed) {
+		end = len(keyed)
+	}
+	return buildPage(page, int64(len(keyed)), keyed[start:end]), nil
+}
+
+// rowLess orders rows by sort key, then by ID.
+func rowLess(a, b pageRow) bool {
+	if a.SortKey != b.SortKey {
+		return a.SortKey < b.SortKey
+	}
+	return a.ID.Hex() < b.ID.Hex()
+}
+
+// sortRows orders rows held in memory the way the aggregation's $sort does.
+func sortRows(page Page, rows []ServiceTraffic) []pageRow {
+	keyed := make([]pageRow, 0, len(rows))
+	for _, row := range rows {
+		keyed = append(keyed, pageRow{ServiceTraffic: row, SortKey: sortKey(page.Sort, row)})
+	}
+	sort.SliceStable(keyed, func(i, j int) bool {
+		if page.Descending {
+			return rowLess(keyed[j], keyed[i])
+		}
+		return rowLess(keyed[i], keyed[j])
+	})
+	return keyed
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrafficRepository reads and writes network traffic records.
+type TrafficRepository interface {
+	InsertTraffic(ctx context.Context, database, collection string, traffic network.NetworkTraffic) error
+	InsertTrafficBatch(ctx context.Context, database, collection string, records []network.NetworkTraffic) ([]RecordError, error)
+	FindTraffic(ctx context.Context, database, collection string) ([]network.NetworkTraffic, error)
+	AggregateTrafficWithService(ctx context.Context, query TrafficQuery) (*TrafficPage, error)
+	StreamTrafficWithService(ctx context.Context, query TrafficQuery, fn func(ServiceTraffic) error) error
+	SummarizeFlows(ctx context.Context, query FlowQuery) ([]FlowSummary, error)
+	TrafficStats(ctx context.Context, query StatsQuery) ([]TrafficBucket, error)
+	FindUnknownEndpoints(ctx context.Context, query FlowQuery) (*UnknownEndpoints, error)
+}
+
+// ServiceRepository reads and writes the service inventory.
+type ServiceRepository interface {
+	InsertService(ctx context.Context, database, collection string, svc service.ServiceData) error
+	FindServices(ctx context.Context, database, collection string) ([]service.ServiceData, error)
+	FindServiceByName(ctx context.Context, database, collection, namespace, name string) (*service.ServiceData, error)
+	UpsertService(ctx context.Context, database, collection string, svc service.ServiceData) error
+	MarkServicesRemoved(ctx context.Context, database, collection, source string, scope service.Scope, keep []service.ServiceID, removedAt time.Time) (int, error)
+}
+
+// Repository is the full data layer used by the API.
+type Repository interface {
+	TrafficRepository
+	ServiceRepository
+}
+
+// RecordError reports why the record at Index of a batch was not written.
+type RecordError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// Direction describes a flow relative to the service being queried.
+type Direction string
+
+const (
+	DirectionInbound  Direction = "inbound"
+	DirectionOutbound Direction = "outbound"
+)
+
+// ServiceRef identifies the service found on one side of a flow, the
+// address it was matched on and, when the flow's port is one the service
+// exposes, that named port.
+type ServiceRef struct {
+	Name      string               `bson:"name" json:"name"`
+	Namespace string               `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	IP        string               `bson:"ip_address" json:"ip_address"`
+	Port      *service.ServicePort `bson:"port,omitempty" json:"port,omitempty"`
+}
+
+// ServiceTraffic is a network flow enriched with the services found on
+// either end of it.
+type ServiceTraffic struct {
+	ID                     primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	network.NetworkTraffic `bson:",inline"`
+	SourceService          *ServiceRef `bson:"source_service,omitempty" json:"source_service,omitempty"`
+	DestinationService     *ServiceRef `bson:"destination_service,omitempty" json:"destination_service,omitempty"`
+	Direction              Direction   `bson:"direction,omitempty" json:"direction,omitempty"`
+	// SourceWorkload and DestinationWorkload name the workloads owning the
+	// pods on either end, when a PodResolver is configured.
+	SourceWorkload      *service.Workload `bson:"source_workload,omitempty" json:"source_workload,omitempty"`
+	DestinationWorkload *service.Workload `bson:"destination_workload,omitempty" json:"destination_workload,omitempty"`
+}
+
+// Endpoint is one side of a summarized flow: a service from the inventory,
+// or the bare IP address when no service matches it. A bare IP owned by a
+// pod carries the pod's namespace and workload when a PodResolver is
+// configured.
+type Endpoint struct {
+	Service   string            `bson:"service,omitempty" json:"service,omitempty"`
+	Namespace string            `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	IP        string            `bson:"ip,omitempty" json:"ip,omitempty"`
+	Workload  *service.Workload `bson:"workload,omitempty" json:"workload,omitempty"`
+}
+
+// inNamespace reports whether the endpoint is a service in namespace.
+func (e Endpoint) inNamespace(namespace string) bool {
+	return e.Service != "" && e.Namespace == namespace
+}
+
+// FlowSummary aggregates every flow between the same pair of endpoints.
+// FirstSeen and LastSeen are zero when none of the flows has an observed_at
+// time.
+type FlowSummary struct {
+	Source      Endpoint                `bson:"source" json:"source"`
+	Destination Endpoint                `bson:"destination" json:"destination"`
+	Flows       int                     `bson:"flows" json:"flows"`
+	Statuses    []network.TrafficStatus `bson:"statuses" json:"statuses"`
+	FirstSeen   time.Time               `bson:"first_seen" json:"first_seen"`
+	LastSeen    time.Time               `bson:"last_seen" json:"last_seen"`
+}
@@ -0,0 +1,282 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	"example.com/m/internal/service"
+)
+
+// ServiceResolver looks up the live service that owns an IP address, and
+// the addresses of the live services in a namespace.
+// *service.ServiceCache implements it.
+type ServiceResolver interface {
+	LookupIP(ip string) (*service.ServiceData, bool)
+	NamespaceIPs(namespace string) []string
+}
+
+// resolvingRepository fills in the service refs the inventory could not
+// provide from a ServiceResolver, so flows to services that have not been
+// written to the inventory yet are still attributed.
+type resolvingRepository struct {
+	Repository
+	resolver ServiceResolver
+}
+
+// WithResolver wraps repo so that traffic rows missing a source or
+// destination service are enriched from resolver. The inventory match wins
+// when both know the address.
+func WithResolver(repo Repository, resolver ServiceResolver) Repository {
+	return &resolvingRepository{Repository: repo, resolver: resolver}
+}
+
+// AggregateTrafficWithService returns a page of traffic with missing service
+// refs resolved. A namespace filter also keeps the flows to or from the
+// addresses the resolver knows in that namespace, so the page is still cut
+// by the wrapped store.
+func (r *resolvingRepository) AggregateTrafficWithService(ctx context.Context, query TrafficQuery) (*TrafficPage, error) {
+	query.Filter = r.withNamespaceIPs(query.Filter)
+	page, err := r.Repository.AggregateTrafficWithService(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Items {
+		r.resolve(&page.Items[i])
+	}
+	return page, nil
+}
+
+// StreamTrafficWithService streams traffic with missing service refs
+// resolved, applying a namespace filter as AggregateTrafficWithService does.
+func (r *resolvingRepository) StreamTrafficWithService(ctx context.Context, query TrafficQuery, fn func(ServiceTraffic) error) error {
+	query.Filter = r.withNamespaceIPs(query.Filter)
+	return r.Repository.StreamTrafficWithService(ctx, query, func(row ServiceTraffic) error {
+		r.resolve(&row)
+		return fn(row)
+	})
+}
+
+// withNamespaceIPs adds the addresses the resolver knows in the filter's
+// namespace to the ones the filter already holds.
+func (r *resolvingRepository) withNamespaceIPs(filter TrafficFilter) TrafficFilter {
+	if filter.Namespace != "" {
+		filter.namespaceIPs = slices.Concat(filter.namespaceIPs, r.resolver.NamespaceIPs(filter.Namespace))
+	}
+	return filter
+}
+
+// SummarizeFlows returns the flow summaries with bare IP endpoints resolved
+// to their services. Summaries that end up between the same pair of
+// endpoints are merged. The namespace is applied once the endpoints are
+// resolved, so flows attributed to it only by the resolver are kept.
+func (r *resolvingRepository) SummarizeFlows(ctx context.Context, query FlowQuery) ([]FlowSummary, error) {
+	all := query
+	all.Namespace = ""
+	summaries, err := r.Repository.SummarizeFlows(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+
+	type pair struct{ source, destination Endpoint }
+	index := make(map[pair]int)
+	results := make([]FlowSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		key := pair{r.resolveEndpoint(summary.Source), r.resolveEndpoint(summary.Destination)}
+		if query.Namespace != "" && !key.source.inNamespace(query.Namespace) && !key.destination.inNamespace(query.Namespace) {
+			continue
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, FlowSummary{Source: key.source, Destination: key.destination})
+		}
+		results[i].Flows += summary.Flows
+		for _, status := range summary.Statuses {
+			if !slices.Contains(results[i].Statuses, status) {
+				results[i].Statuses = append(results[i].Statuses, status)
+			}
+		}
+		widenSeen(&results[i].FirstSeen, &results[i].LastSeen, summary.FirstSeen)
+		widenSeen(&results[i].FirstSeen, &results[i].LastSeen, summary.LastSeen)
+	}
+	return results, nil
+}
+
+// FindUnknownEndpoints returns the unknown endpoints less the addresses the
+// resolver knows a service for. With a namespace, the endpoints are
+// gathered from the resolved flow summaries, since which flows belong to the
+// namespace is only known once they are resolved.
+func (r *resolvingRepository) FindUnknownEndpoints(ctx context.Context, query FlowQuery) (*UnknownEndpoints, error) {
+	if query.Namespace != "" {
+		summaries, err := r.SummarizeFlows(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return unknownEndpointsOf(summaries), nil
+	}
+
+	endpoints, err := r.Repository.FindUnknownEndpoints(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	known := func(endpoint UnknownEndpoint) bool {
+		_, ok := r.resolver.LookupIP(endpoint.IP)
+		return ok
+	}
+	endpoints.Internal = slices.DeleteFunc(endpoints.Internal, known)
+	endpoints.External = slices.DeleteFunc(endpoints.External, known)
+	return endpoints, nil
+}
+
+func (r *resolvingRepository) resolve(row *ServiceTraffic) {
+	if row.SourceService == nil {
+		row.SourceService = r.lookup(row.SourceIP, row.SourcePort)
+	}
+	if row.DestinationService == nil {
+		row.DestinationService = r.lookup(row.DestinationIP, row.DestinationPort)
+	}
+}
+
+// unknownEndpointsOf collects the bare IP endpoints of the summaries, with
+// the flows they took part in and when they were first and last seen.
+func unknownEndpointsOf(summaries []FlowSummary) *UnknownEndpoints {
+	index := make(map[string]int)
+	var endpoints []UnknownEndpoint
+	for _, summary := range summaries {
+		sides := []Endpoint{summary.Source}
+		if summary.Destination != summary.Source {
+			sides = append(sides, summary.Destination)
+		}
+		for _, side := range sides {
+			if side.Service != "" {
+				continue
+			}
+			i, ok := index[side.IP]
+			if !ok {
+				i = len(endpoints)
+				index[side.IP] = i
+				endpoints = append(endpoints, UnknownEndpoint{IP: side.IP})
+			}
+			endpoints[i].Flows += summary.Flows
+			widenSeen(&endpoints[i].FirstSeen, &endpoints[i].LastSeen, summary.FirstSeen)
+			widenSeen(&endpoints[i].FirstSeen, &endpoints[i].LastSeen, summary.LastSeen)
+		}
+	}
+	sortUnknownEndpoints(endpoints)
+	return splitUnknownEndpoints(endpoints)
+}
+
+// resolveEndpoint replaces a bare IP endpoint with the service owning the
+// address, leaving it unchanged when no service does.
+func (r *resolvingRepository) resolveEndpoint(endpoint Endpoint) Endpoint {
+	if endpoint.Service != "" {
+		return endpoint
+	}
+	svc, ok := r.resolver.LookupIP(endpoint.IP)
+	if !ok {
+		return endpoint
+	}
+	return Endpoint{Service: svc.Name, Namespace: svc.Namespace}
+}
+
+// lookup builds a ServiceRef for ip, or returns nil when no service owns it.
+func (r *resolvingRepository) lookup(ip string, port int) *ServiceRef {
+	svc, ok := r.resolver.LookupIP(ip)
+	if !ok {
+		return nil
+	}
+	return &ServiceRef{Name: svc.Name, Namespace: svc.Namespace, IP: ip, Port: svc.PortFor(ip, int32(port))}
+}
+
+// PodResolver looks up the pod that owns an IP address.
+// *service.PodResolver implements it.
+type PodResolver interface {
+	LookupPod(ip string) (*service.PodData, bool)
+}
+
+// workloadRepository attributes traffic rows to the workloads owning the
+// pods on either end of the flow.
+type workloadRepository struct {
+	Repository
+	pods PodResolver
+}
+
+// WithPodResolver wraps repo so that traffic rows are attributed to the
+// workloads of the pods found by pods.
+func WithPodResolver(repo Repository, pods PodResolver) Repository {
+	return &workloadRepository{Repository: repo, pods: pods}
+}
+
+// AggregateTrafficWithService returns a page of traffic with workloads attached.
+func (r *workloadRepository) AggregateTrafficWithService(ctx context.Context, query TrafficQuery) (*TrafficPage, error) {
+	page, err := r.Repository.AggregateTrafficWithService(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Items {
+		r.resolve(&page.Items[i])
+	}
+	return page, nil
+}
+
+// StreamTrafficWithService streams traffic with workloads attached.
+func (r *workloadRepository) StreamTrafficWithService(ctx context.Context, query TrafficQuery, fn func(ServiceTraffic) error) error {
+	return r.Repository.StreamTrafficWithService(ctx, query, func(row ServiceTraffic) error {
+		r.resolve(&row)
+		return fn(row)
+	})
+}
+
+func (r *workloadRepository) resolve(row *ServiceTraffic) {
+	if pod, ok := r.pods.LookupPod(row.SourceIP); ok {
+		row.SourceWorkload = pod.Workload
+	}
+	if pod, ok := r.pods.LookupPod(row.DestinationIP); ok {
+		row.DestinationWorkload = pod.Workload
+	}
+}
+
+// SummarizeFlows returns the flow summaries with the workloads of bare IP
+// endpoints attached.
+func (r *workloadRepository) SummarizeFlows(ctx context.Context, query FlowQuery) ([]FlowSummary, error) {
+	summaries, err := r.Repository.SummarizeFlows(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range summaries {
+		r.attach(&summaries[i].Source)
+		r.attach(&summaries[i].Destination)
+	}
+	return summaries, nil
+}
+
+// FindUnknownEndpoints returns the unknown endpoints with the workloads of
+// the pods behind them attached.
+func (r *workloadRepository) FindUnknownEndpoints(ctx context.Context, query FlowQuery) (*UnknownEndpoints, error) {
+	endpoints, err := r.Repository.FindUnknownEndpoints(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range [][]UnknownEndpoint{endpoints.Internal, endpoints.External} {
+		for i := range list {
+			if pod, ok := r.pods.LookupPod(list[i].IP); ok {
+				list[i].Namespace = pod.Namespace
+				list[i].Workload = pod.Workload
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// attach sets the workload of a bare IP endpoint. Service endpoints stand
+// for every pod behind the service and are left alone.
+func (r *workloadRepository) attach(endpoint *Endpoint) {
+	if endpoint.Service != "" {
+		return
+	}
+	if pod, ok := r.pods.LookupPod(endpoint.IP); ok && pod.Workload != nil {
+		endpoint.Namespace = pod.Namespace
+		endpoint.Workload = pod.Workload
+	}
+}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"example.com/m/internal/network"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultSeedBatchSize is the number of documents InsertJSONData writes per
+// bulk write when SeedOptions sets no batch size.
+const DefaultSeedBatchSize = 500
+
+var (
+	// ServiceKey identifies a service document by its name and namespace.
+	ServiceKey = []string{"name", "namespace"}
+	// TrafficKey identifies a flow by its 5-tuple and the time it was observed.
+	TrafficKey = []string{"source_ip", "source_port", "destination_ip", "destination_port", "protocol", "observed_at"}
+)
+
+// SeedOptions controls how InsertJSONData loads a file.
+type SeedOptions struct {
+	// BatchSize is the number of documents sent per bulk write.
+	BatchSize int
+	// Key names the fields that identify a document. When it is set each
+	// document replaces the stored one with the same key, or is inserted if
+	// there is none, so loading a file twice does not duplicate it. A key
+	// field missing from a document matches stored documents missing it too.
+	// Without a key every document is inserted.
+	Key []string
+	// Normalize, when set, checks each document and returns the form it is
+	// stored in; the upsert key is read from that form too. The load stops
+	// at the first document it rejects.
+	Normalize func(bson.Raw) (bson.Raw, error)
+}
+
+// TrafficSeedOptions upserts flows by TrafficKey, validating and
+// normalizing each one.
+func TrafficSeedOptions() SeedOptions {
+	return SeedOptions{Key: TrafficKey, Normalize: NormalizeTrafficDocument}
+}
+
+// ServiceSeedOptions upserts services by ServiceKey.
+func ServiceSeedOptions() SeedOptions {
+	return SeedOptions{Key: ServiceKey}
+}
+
+// NormalizeTrafficDocument checks that a document decodes to a valid network
+// traffic record and returns the record as it is stored by InsertTraffic:
+// with its status and protocol in their canonical spelling and its numeric
+// addresses. Fields that are not part of the record are dropped.
+func NormalizeTrafficDocument(doc bson.Raw) (bson.Raw, error) {
+	var record network.NetworkTraffic
+	if err := bson.Unmarshal(doc, &record); err != nil {
+		return nil, err
+	}
+	record.Normalize()
+	if err := record.Validate(); err != nil {
+		return nil, err
+	}
+	normalized, err := bson.Marshal(newTrafficDocument(record))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode traffic record: %w", err)
+	}
+	return normalized, nil
+}
+
+// SeedSummary counts what InsertJSONData did with the documents it read.
+type SeedSummary struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	// Skipped counts documents whose key matched a stored document that
+	// was already identical.
+	Skipped int `json:"skipped"`
+}
+
+func (s *SeedSummary) add(result *mongo.BulkWriteResult) {
+	s.Inserted += int(result.InsertedCount + result.UpsertedCount)
+	s.Updated += int(result.ModifiedCount)
+	s.Skipped += int(result.MatchedCount - result.ModifiedCount)
+}
+
+// InsertJSONData loads a file holding a JSON array of documents, or one
+// document per line, into the collection. The file is decoded as a stream
+// and written in batches, so its size is not limited by memory or by a
+// single request's timeout; ctx bounds the whole load. Documents are read
+// as MongoDB extended JSON. On error the summary reports what was written
+// before the load stopped.
+func (m *MongoClient) InsertJSONData(ctx context.Context, dbName, collectionName, filePath string, opts SeedOptions) (*SeedSummary, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := newDocumentReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := m.client.Database(dbName).Collection(collectionName)
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultSeedBatchSize
+	}
+
+	summary := &SeedSummary{}
+	batch := make([]mongo.WriteModel, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, batch)
+		if result != nil {
+			summary.add(result)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write documents into MongoDB: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for i := 0; ; i++ {
+		doc, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+		if opts.Normalize != nil {
+			if doc, err = opts.Normalize(doc); err != nil {
+				return summary, fmt.Errorf("invalid document %d: %w", i, err)
+			}
+		}
+		batch = append(batch, seedModel(doc, opts.Key))
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// seedModel is the write that stores doc: an upsert on the key fields, or a
+// plain insert when there is no key.
+func seedModel(doc bson.Raw, key []string) mongo.WriteModel {
+	if len(key) == 0 {
+		return mongo.NewInsertOneModel().SetDocument(doc)
+	}
+	filter := make(bson.D, 0, len(key))
+	for _, field := range key {
+		var value interface{}
+		if v, err := doc.LookupErr(field); err == nil {
+			value = v
+		}
+		filter = append(filter, bson.E{Key: field, Value: value})
+	}
+	return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true)
+}
+
+// documentReader decodes documents one at a time from a JSON array or from
+// a stream of JSON values such as NDJSON. Each document is read as MongoDB
+// extended JSON, so values such as {"$date": "..."} keep their BSON types
+// the way they would with mongoimport.
+type documentReader struct {
+	decoder *json.Decoder
+	array   bool
+	count   int
+}
+
+func newDocumentReader(r io.Reader) (*documentReader, error) {
+	buffered := bufio.NewReader(r)
+	array := false
+	for {
+		b, err := buffered.Peek(1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JSON data: %w", err)
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			buffered.Discard(1)
+			continue
+		}
+		array = b[0] == '['
+		break
+	}
+
+	reader := &documentReader{decoder: json.NewDecoder(buffered), array: array}
+	if array {
+		if _, err := reader.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON data: %w", err)
+		}
+	}
+	return reader, nil
+}
+
+// next returns the next document, or io.EOF once every document was read.
+func (r *documentReader) next() (bson.Raw, error) {
+	if r.array && !r.decoder.More() {
+		if _, err := r.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON data: %w", err)
+		}
+		r.array = false
+		return nil, io.EOF
+	}
+
+	var element json.RawMessage
+	if err := r.decoder.Decode(&element); errors.Is(err, io.EOF) && !r.array {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal document %d: %w", r.count, err)
+	}
+
+	var doc bson.Raw
+	if err := bson.UnmarshalExtJSON(element, false, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse document %d: %w", r.count, err)
+	}
+	r.count++
+	return doc, nil
+}
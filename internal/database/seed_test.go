@@ -0,0 +1,125 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDocumentReader(t *testing.T) {
+	readAll := func(t *testing.T, input string) ([]bson.Raw, error) {
+		reader, err := newDocumentReader(strings.NewReader(input))
+		if err != nil {
+			return nil, err
+		}
+		var docs []bson.Raw
+		for {
+			doc, err := reader.next()
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			if err != nil {
+				return docs, err
+			}
+			docs = append(docs, doc)
+		}
+	}
+
+	t.Run("Array", func(t *testing.T) {
+		docs, err := readAll(t, ` [{"name": "Auth"}, {"observed_at": {"$date": "2024-11-20T09:02:11Z"}}] `)
+		assert.NoError(t, err)
+		if assert.Len(t, docs, 2) {
+			assert.Equal(t, "Auth", docs[0].Lookup("name").StringValue())
+			assert.Equal(t, time.Date(2024, 11, 20, 9, 2, 11, 0, time.UTC), docs[1].Lookup("observed_at").Time().UTC())
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		docs, err := readAll(t, "{\"name\": \"Auth\"}\n{\"name\": \"Gaming UI\"}\n")
+		assert.NoError(t, err)
+		assert.Len(t, docs, 2)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		for _, input := range []string{"", "[]", " \n"} {
+			docs, err := readAll(t, input)
+			assert.NoError(t, err)
+			assert.Empty(t, docs)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		docs, err := readAll(t, `[{"name": "Auth"}, {"name": ]`)
+		assert.Error(t, err)
+		assert.Len(t, docs, 1)
+
+		_, err = readAll(t, `[{"observed_at": {"$date": "yesterday"}}]`)
+		assert.Error(t, err)
+	})
+}
+
+func TestSeedModel(t *testing.T) {
+	doc, err := bson.Marshal(bson.D{{Key: "name", Value: "Auth"}, {Key: "ip_addresses", Value: bson.A{"10.128.72.20"}}})
+	assert.NoError(t, err)
+
+	t.Run("Insert", func(t *testing.T) {
+		model, ok := seedModel(doc, nil).(*mongo.InsertOneModel)
+		if assert.True(t, ok) {
+			assert.Equal(t, bson.Raw(doc), model.Document)
+		}
+	})
+
+	t.Run("Upsert", func(t *testing.T) {
+		model, ok := seedModel(doc, []string{"name", "namespace"}).(*mongo.ReplaceOneModel)
+		if assert.True(t, ok) {
+			filter := model.Filter.(bson.D)
+			assert.Equal(t, "name", filter[0].Key)
+			assert.Equal(t, "Auth", filter[0].Value.(bson.RawValue).StringValue())
+			assert.Equal(t, bson.E{Key: "namespace", Value: nil}, filter[1])
+			assert.True(t, *model.Upsert)
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		var summary SeedSummary
+		summary.add(&mongo.BulkWriteResult{UpsertedCount: 3, MatchedCount: 5, ModifiedCount: 2})
+		summary.add(&mongo.BulkWriteResult{InsertedCount: 1})
+		assert.Equal(t, SeedSummary{Inserted: 4, Updated: 2, Skipped: 3}, summary)
+	})
+}
+
+func TestNormalizeTrafficDocument(t *testing.T) {
+	valid, err := bson.Marshal(bson.D{
+		{Key: "source_ip", Value: "10.128.72.20"}, {Key: "source_port", Value: 27892},
+		{Key: "destination_ip", Value: "10.128.24.14"}, {Key: "destination_port", Value: 5432},
+		{Key: "status", Value: "critical"}, {Key: "protocol", Value: "tcp"},
+	})
+	assert.NoError(t, err)
+	doc, err := NormalizeTrafficDocument(valid)
+	assert.NoError(t, err)
+	assert.Equal(t, "Critical", doc.Lookup("status").StringValue(), "the canonical status is stored")
+	assert.Equal(t, "TCP", doc.Lookup("protocol").StringValue())
+	assert.Equal(t, int64(176166926), doc.Lookup("destination_ip_num").Int64())
+
+	model := seedModel(doc, TrafficKey).(*mongo.ReplaceOneModel)
+	assert.Equal(t, doc, model.Replacement)
+	filter := model.Filter.(bson.D)
+	assert.Equal(t, "TCP", filter[4].Value.(bson.RawValue).StringValue(), "the key is read from the normalized record")
+
+	for _, fields := range []bson.D{
+		{{Key: "source_ip", Value: "nowhere"}, {Key: "status", Value: "OK"}},
+		{{Key: "source_ip", Value: "10.128.72.20"}, {Key: "destination_ip", Value: "10.128.24.14"}, {Key: "status", Value: "fine"}},
+		{{Key: "source_ip", Value: "10.128.72.20"}, {Key: "destination_ip", Value: "10.128.24.14"}},
+	} {
+		invalid, err := bson.Marshal(fields)
+		assert.NoError(t, err)
+		_, err = NormalizeTrafficDocument(invalid)
+		assert.Error(t, err, "%v", fields)
+	}
+}
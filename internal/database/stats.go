@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"example.com/m/internal/network"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BucketSize is the width of the time buckets traffic statistics are grouped into.
+type BucketSize string
+
+const (
+	BucketMinute BucketSize = "minute"
+	BucketHour   BucketSize = "hour"
+	BucketDay    BucketSize = "day"
+)
+
+// ParseBucketSize validates a bucket size from a request. An empty string
+// selects hourly buckets.
+func ParseBucketSize(s string) (BucketSize, error) {
+	switch bucket := BucketSize(s); bucket {
+	case "":
+		return BucketHour, nil
+	case BucketMinute, BucketHour, BucketDay:
+		return bucket, nil
+	default:
+		return "", fmt.Errorf("unsupported bucket size %q", s)
+	}
+}
+
+// truncate returns the start of the UTC bucket holding t, as $dateTrunc does.
+func (b BucketSize) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch b {
+	case BucketMinute:
+		return t.Truncate(time.Minute)
+	case BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Hour)
+	}
+}
+
+// StatsQuery describes which service's traffic to count and how to bucket it.
+type StatsQuery struct {
+	Collections
+	ServiceName string
+	// Namespace picks ServiceName's namespace, as in TrafficQuery.
+	Namespace string
+	Bucket    BucketSize
+	// From and To bound the flows' observed_at time to [From, To), as in TrafficQuery.
+	From time.Time
+	To   time.Time
+}
+
+// TrafficBucket counts the flows to or from a service observed in one time
+// bucket, in total and per status.
+type TrafficBucket struct {
+	Start    time.Time                     `json:"start"`
+	Total    int                           `json:"total"`
+	Statuses map[network.TrafficStatus]int `json:"statuses"`
+}
+
+// statsRow is one group produced by the stats aggregation: the number of
+// flows with a given status in a bucket.
+type statsRow struct {
+	ID struct {
+		Start  time.Time             `bson:"start"`
+		Status network.TrafficStatus `bson:"status"`
+	} `bson:"_id"`
+	Count int `bson:"count"`
+}
+
+// TrafficStats counts the service's flows per time bucket and status. Flows
+// without an observed_at time cannot be placed in a bucket and are left out.
+// Buckets are aligned to UTC and returned oldest first; buckets without any
+// flows are omitted. Bucketing uses $dateTrunc, so it needs MongoDB 5.0 or
+// newer.
+func TrafficStats(ctx context.Context, client *mongo.Client, query StatsQuery) ([]TrafficBucket, error) {
+	db := client.Database(query.Database)
+	if err := ensureCollectionsExist(ctx, db, query.NetworkCollection, query.ServiceCollection); err != nil {
+		return nil, err
+	}
+
+	svc, err := findServiceByName(ctx, db.Collection(query.ServiceCollection), query.Namespace, query.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service IP: %w", err)
+	}
+	if len(svc.IPs) == 0 {
+		return nil, fmt.Errorf("service %s has no IP addresses", query.ServiceName)
+	}
+
+	observed := append(timeRangeFilter(query.From, query.To), bson.E{Key: "$type", Value: "date"})
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: append(serviceFlowFilter(svc.IPs), bson.E{Key: "observed_at", Value: observed})}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "start", Value: bson.D{{Key: "$dateTrunc", Value: bson.D{
+					{Key: "date", Value: "$observed_at"},
+					{Key: "unit", Value: string(query.Bucket)},
+					{Key: "timezone", Value: "UTC"},
+				}}}},
+				{Key: "status", Value: "$status"},
+			}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id.start", Value: 1}}}},
+	}
+
+	cursor, err := db.Collection(query.NetworkCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate data: %w", err)
+	}
+
+	var rows []statsRow
+	err = decodeEach(ctx, cursor, func(row statsRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode traffic stats: %w", err)
+	}
+	return collectBuckets(rows), nil
+}
+
+// collectBuckets folds per-status groups into one TrafficBucket per bucket start.
+func collectBuckets(rows []statsRow) []TrafficBucket {
+	buckets := []TrafficBucket{}
+	index := make(map[time.Time]int)
+	for _, row := range rows {
+		start := row.ID.Start.UTC()
+		i, ok := index[start]
+		if !ok {
+			i = len(buckets)
+			index[start] = i
+			buckets = append(buckets, TrafficBucket{Start: start, Statuses: make(map[network.TrafficStatus]int)})
+		}
+		buckets[i].Total += row.Count
+		buckets[i].Statuses[row.ID.Status] += row.Count
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// TrafficStats counts the service's flows per time bucket using the shared client.
+func (m *MongoClient) TrafficStats(ctx context.Context, query StatsQuery) ([]TrafficBucket, error) {
+	return TrafficStats(ctx, m.client, query)
+}
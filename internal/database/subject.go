@@ -0,0 +1,65 @@
+package database
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// trafficSubject is what a traffic query selects flows by: the addresses of
+// a service, or address ranges. Flows that start or end at the subject
+// match, and a flow is inbound when its destination is the subject.
+type trafficSubject struct {
+	ips      []string
+	prefixes []netip.Prefix
+}
+
+// has reports whether ip belongs to the subject.
+func (s trafficSubject) has(ip string) bool {
+	if s.ips != nil {
+		return slices.Contains(s.ips, ip)
+	}
+	return inCIDRs(ip, s.prefixes)
+}
+
+// hasExpr is the aggregation expression equivalent of has for the IP
+// address in field.
+func (s trafficSubject) hasExpr(field string) bson.D {
+	if s.ips != nil {
+		return bson.D{{Key: "$in", Value: bson.A{field, s.ips}}}
+	}
+	return cidrExpr(field, s.prefixes)
+}
+
+// condition is the $match clause selecting the flows whose address on
+// side, "source" or "destination", belongs to the subject. Address ranges
+// are matched on the numeric address field so the index on it is used.
+func (s trafficSubject) condition(side string) bson.D {
+	if s.ips != nil {
+		return bson.D{{Key: side + "_ip", Value: bson.D{{Key: "$in", Value: s.ips}}}}
+	}
+	return rangeCondition(side+"_ip_num", s.prefixes)
+}
+
+// trafficMatch builds the $match document selecting the subject's flows
+// that pass the filter and fall in the [from, to) time range.
+func trafficMatch(subject trafficSubject, filter TrafficFilter, from, to time.Time) bson.D {
+	clauses := []bson.D{{{Key: "$or", Value: bson.A{
+		subject.condition("source"),
+		subject.condition("destination"),
+	}}}}
+	clauses = append(clauses, filter.matchConditions(subject)...)
+	if observed := timeRangeFilter(from, to); observed != nil {
+		clauses = append(clauses, bson.D{{Key: "observed_at", Value: observed}})
+	}
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	and := make(bson.A, len(clauses))
+	for i, clause := range clauses {
+		and[i] = clause
+	}
+	return bson.D{{Key: "$and", Value: and}}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"example.com/m/internal/service"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UnknownEndpoint is an IP address seen in network traffic that no service
+// in the inventory claims.
+type UnknownEndpoint struct {
+	IP        string    `bson:"ip" json:"ip"`
+	Flows     int       `bson:"flows" json:"flows"`
+	FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+	// Namespace and Workload locate the pod behind the address, when a
+	// PodResolver is configured and knows it.
+	Namespace string            `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	Workload  *service.Workload `bson:"workload,omitempty" json:"workload,omitempty"`
+}
+
+// UnknownEndpoints lists the unregistered addresses, split into private
+// (RFC 1918) addresses and public ones. Each list is ordered by flow count,
+// busiest first.
+type UnknownEndpoints struct {
+	Internal []UnknownEndpoint `json:"internal"`
+	External []UnknownEndpoint `json:"external"`
+}
+
+// FindUnknownEndpoints lists the IP addresses on either side of the flows in
+// the network collection that do not match any live service document, with the
+// number of flows they took part in and when they were first and last seen.
+// With a namespace, only flows with a service from that namespace on either
+// end are scanned.
+func FindUnknownEndpoints(ctx context.Context, client *mongo.Client, query FlowQuery) (*UnknownEndpoints, error) {
+	collections := query.Collections
+	db := client.Database(collections.Database)
+	if err := ensureCollectionsExist(ctx, db, collections.NetworkCollection, collections.ServiceCollection); err != nil {
+		return nil, err
+	}
+
+	var pipeline mongo.Pipeline
+	if stage, ok := query.namespaceFilter().serviceMatchStage(); ok {
+		pipeline = append(pipeline, serviceLookupStages(collections.ServiceCollection)...)
+		pipeline = append(pipeline, stage)
+	}
+	pipeline = append(pipeline,
+		// Step 1: One document per distinct address on each flow
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "observed_at", Value: 1},
+			{Key: "ip", Value: bson.D{{Key: "$setUnion", Value: bson.A{bson.A{"$source_ip", "$destination_ip"}}}}},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$ip"}},
+		// Step 2: Count the flows per address
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$ip"},
+			{Key: "flows", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "first_seen", Value: bson.D{{Key: "$min", Value: "$observed_at"}}},
+			{Key: "last_seen", Value: bson.D{{Key: "$max", Value: "$observed_at"}}},
+		}}},
+		// Step 3: Drop the addresses a service claims
+		liveServiceLookup(collections.ServiceCollection, "_id", "services"),
+		bson.D{{Key: "$match", Value: bson.D{{Key: "services", Value: bson.A{}}}}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "ip", Value: "$_id"},
+			{Key: "flows", Value: 1},
+			{Key: "first_seen", Value: 1},
+			{Key: "last_seen", Value: 1},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "flows", Value: -1}, {Key: "ip", Value: 1}}}},
+	)
+
+	cursor, err := db.Collection(collections.NetworkCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate data: %w", err)
+	}
+
+	var endpoints []UnknownEndpoint
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode unknown endpoints: %w", err)
+	}
+	return splitUnknownEndpoints(endpoints), nil
+}
+
+// splitUnknownEndpoints separates private addresses from public ones,
+// keeping the order endpoints are given in.
+func splitUnknownEndpoints(endpoints []UnknownEndpoint) *UnknownEndpoints {
+	result := &UnknownEndpoints{Internal: []UnknownEndpoint{}, External: []UnknownEndpoint{}}
+	for _, endpoint := range endpoints {
+		if addr, err := netip.ParseAddr(endpoint.IP); err == nil && addr.IsPrivate() {
+			result.Internal = append(result.Internal, endpoint)
+		} else {
+			result.External = append(result.External, endpoint)
+		}
+	}
+	return result
+}
+
+// widenSeen extends the first/last seen range held in memory to include
+// observed, as $min and $max do in the aggregations. A zero time is ignored.
+func widenSeen(first, last *time.Time, observed time.Time) {
+	if observed.IsZero() {
+		return
+	}
+	if first.IsZero() || observed.Before(*first) {
+		*first = observed
+	}
+	if observed.After(*last) {
+		*last = observed
+	}
+}
+
+// sortUnknownEndpoints orders endpoints held in memory the way the
+// aggregation's $sort does.
+func sortUnknownEndpoints(endpoints []UnknownEndpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Flows != endpoints[j].Flows {
+			return endpoints[i].Flows > endpoints[j].Flows
+		}
+		return endpoints[i].IP < endpoints[j].IP
+	})
+}
+
+// FindUnknownEndpoints lists the unregistered addresses using the shared client.
+func (m *MongoClient) FindUnknownEndpoints(ctx context.Context, query FlowQuery) (*UnknownEndpoints, error) {
+	return FindUnknownEndpoints(ctx, m.client, query)
+}
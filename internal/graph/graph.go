@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"net/netip"
+	"sort"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+)
+
+// ExternalNodeID is the node every public IP outside the inventory is grouped into.
+const ExternalNodeID = "external"
+
+// NodeKind says what a node in the graph stands for.
+type NodeKind string
+
+const (
+	// KindService is a service from the inventory.
+	KindService NodeKind = "service"
+	// KindWorkload is a workload whose pods no service claims.
+	KindWorkload NodeKind = "workload"
+	// KindUnknown is an internal IP address that no service claims.
+	KindUnknown NodeKind = "unknown"
+	// KindExternal groups every public IP address.
+	KindExternal NodeKind = "external"
+)
+
+// Node is a vertex of the service dependency graph.
+type Node struct {
+	ID    string   `json:"id"`
+	Label string   `json:"label"`
+	Kind  NodeKind `json:"kind"`
+}
+
+// Edge is a directed service-to-service dependency.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Flows  int    `json:"flows"`
+	// Status is the worst status of the edge's flows, empty when none of
+	// them recorded one.
+	Status network.TrafficStatus `json:"status,omitempty"`
+}
+
+// Graph is the directed service dependency graph built from network traffic.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Build turns flow summaries into a graph. Flows between endpoints that map
+// to the same pair of nodes are merged, and each edge carries the worst
+// status seen on any of its flows.
+func Build(flows []database.FlowSummary) *Graph {
+	nodes := make(map[string]Node)
+	edges := make(map[[2]string]*Edge)
+
+	for _, flow := range flows {
+		source := nodeFor(flow.Source)
+		target := nodeFor(flow.Destination)
+		nodes[source.ID] = source
+		nodes[target.ID] = target
+
+		key := [2]string{source.ID, target.ID}
+		edge, ok := edges[key]
+		if !ok {
+			edge = &Edge{Source: source.ID, Target: target.ID}
+			edges[key] = edge
+		}
+		edge.Flows += flow.Flows
+		edge.Status = network.WorstStatus(append([]network.TrafficStatus{edge.Status}, flow.Statuses...)...)
+	}
+
+	g := &Graph{Nodes: []Node{}, Edges: []Edge{}}
+	for _, node := range nodes {
+		g.Nodes = append(g.Nodes, node)
+	}
+	for _, edge := range edges {
+		g.Edges = append(g.Edges, *edge)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+	return g
+}
+
+// nodeFor maps a flow endpoint to its node: the service when one is known,
+// then the workload owning the pod, the IP itself when it is internal, and
+// the shared external node otherwise. Services and workloads are told apart
+// by namespace, so same-named ones get their own nodes.
+func nodeFor(endpoint database.Endpoint) Node {
+	if endpoint.Service != "" {
+		name := service.ServiceID{Namespace: endpoint.Namespace, Name: endpoint.Service}.String()
+		return Node{ID: "service:" + name, Label: name, Kind: KindService}
+	}
+	if workload := endpoint.Workload; workload != nil {
+		name := service.ServiceID{Namespace: endpoint.Namespace, Name: workload.Name}.String()
+		return Node{ID: "workload:" + workload.Kind + ":" + name, Label: name, Kind: KindWorkload}
+	}
+	if addr, err := netip.ParseAddr(endpoint.IP); err == nil && (addr.IsPrivate() || addr.IsLoopback()) {
+		return Node{ID: "ip:" + endpoint.IP, Label: endpoint.IP, Kind: KindUnknown}
+	}
+	return Node{ID: ExternalNodeID, Label: "External", Kind: KindExternal}
+}
@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	t.Run("SampleData", func(t *testing.T) {
+		store := database.NewMemoryStore()
+		if err := store.LoadTrafficFile("testdb", "testcollectionB", "../../sample/networkData"); err != nil {
+			t.Fatalf("Failed to load network fixture: %v", err)
+		}
+		if err := store.LoadServiceFile("testdb", "testcollectionA", "../../sample/serviceData"); err != nil {
+			t.Fatalf("Failed to load service fixture: %v", err)
+		}
+
+		flows, err := store.SummarizeFlows(context.Background(), database.FlowQuery{Collections: database.Collections{
+			Database:          "testdb",
+			NetworkCollection: "testcollectionB",
+			ServiceCollection: "testcollectionA",
+		}})
+		assert.NoError(t, err)
+
+		g := Build(flows)
+		assert.Len(t, g.Nodes, 6)
+		assert.Contains(t, g.Edges, Edge{Source: ExternalNodeID, Target: "service:Login Service", Flows: 3, Status: network.StatusOK})
+		assert.Contains(t, g.Edges, Edge{Source: "service:Auth", Target: "service:User Profile DB", Flows: 1, Status: network.StatusWarning})
+		assert.Contains(t, g.Edges, Edge{Source: "service:Gaming UI", Target: "service:Gaming Service", Flows: 1, Status: network.StatusCritical})
+	})
+
+	t.Run("WorstStatusAndUnknownIPs", func(t *testing.T) {
+		g := Build([]database.FlowSummary{
+			{Source: database.Endpoint{IP: "10.0.0.9"}, Destination: database.Endpoint{Service: "db"}, Flows: 2, Statuses: []network.TrafficStatus{network.StatusOK}},
+			{Source: database.Endpoint{IP: "10.0.0.9"}, Destination: database.Endpoint{Service: "db"}, Flows: 1, Statuses: []network.TrafficStatus{network.StatusCritical, network.StatusWarning}},
+		})
+		assert.Equal(t, []Node{
+			{ID: "ip:10.0.0.9", Label: "10.0.0.9", Kind: KindUnknown},
+			{ID: "service:db", Label: "db", Kind: KindService},
+		}, g.Nodes)
+		assert.Equal(t, []Edge{{Source: "ip:10.0.0.9", Target: "service:db", Flows: 3, Status: network.StatusCritical}}, g.Edges)
+	})
+
+	t.Run("NoStatus", func(t *testing.T) {
+		g := Build([]database.FlowSummary{{Source: database.Endpoint{IP: "10.0.0.9"}, Destination: database.Endpoint{Service: "db"}, Flows: 1}})
+		data, err := json.Marshal(g)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), `"status"`)
+	})
+
+	t.Run("Namespaces", func(t *testing.T) {
+		g := Build([]database.FlowSummary{
+			{Source: database.Endpoint{Service: "web", Namespace: "prod"}, Destination: database.Endpoint{Service: "auth", Namespace: "prod"}, Flows: 1, Statuses: []network.TrafficStatus{network.StatusOK}},
+			{Source: database.Endpoint{Service: "web", Namespace: "prod"}, Destination: database.Endpoint{Service: "auth", Namespace: "staging"}, Flows: 1, Statuses: []network.TrafficStatus{network.StatusOK}},
+		})
+		assert.Len(t, g.Nodes, 3)
+		assert.Contains(t, g.Nodes, Node{ID: "service:staging/auth", Label: "staging/auth", Kind: KindService})
+		assert.Len(t, g.Edges, 2)
+	})
+
+	t.Run("Workloads", func(t *testing.T) {
+		api := &service.Workload{Kind: service.KindDeployment, Name: "api"}
+		g := Build([]database.FlowSummary{
+			{Source: database.Endpoint{IP: "10.0.1.1", Namespace: "prod", Workload: api}, Destination: database.Endpoint{Service: "db"}, Flows: 1, Statuses: []network.TrafficStatus{network.StatusOK}},
+			{Source: database.Endpoint{IP: "10.0.1.2", Namespace: "prod", Workload: api}, Destination: database.Endpoint{Service: "db"}, Flows: 2, Statuses: []network.TrafficStatus{network.StatusOK}},
+		})
+		assert.Equal(t, []Node{
+			{ID: "service:db", Label: "db", Kind: KindService},
+			{ID: "workload:Deployment:prod/api", Label: "prod/api", Kind: KindWorkload},
+		}, g.Nodes)
+		assert.Equal(t, []Edge{{Source: "workload:Deployment:prod/api", Target: "service:db", Flows: 3, Status: network.StatusOK}}, g.Edges)
+	})
+}
+
+func TestRender(t *testing.T) {
+	g := &Graph{
+		Nodes: []Node{
+			{ID: ExternalNodeID, Label: "External", Kind: KindExternal},
+			{ID: "service:Auth", Label: "Auth", Kind: KindService},
+			{ID: "service:User \"Profile\" DB", Label: "User \"Profile\" DB", Kind: KindService},
+		},
+		Edges: []Edge{
+			{Source: ExternalNodeID, Target: "service:Auth", Flows: 4, Status: network.StatusOK},
+			{Source: "service:Auth", Target: "service:User \"Profile\" DB", Flows: 1, Status: network.StatusCritical},
+		},
+	}
+
+	t.Run("DOT", func(t *testing.T) {
+		dot := g.DOT()
+		assert.Contains(t, dot, "digraph services {")
+		assert.Contains(t, dot, `"external" [label="External", shape=ellipse, style=dashed];`)
+		assert.Contains(t, dot, `"service:Auth" -> "service:User \"Profile\" DB" [label="1", color="#c62828", fontcolor="#c62828"];`)
+	})
+
+	t.Run("Mermaid", func(t *testing.T) {
+		mermaid := g.Mermaid()
+		assert.Contains(t, mermaid, "flowchart LR\n")
+		assert.Contains(t, mermaid, `n2("User #quot;Profile#quot; DB")`)
+		assert.Contains(t, mermaid, "n0 -->|4| n1\n")
+		assert.Contains(t, mermaid, "linkStyle 1 stroke:#c62828,color:#c62828\n")
+	})
+}
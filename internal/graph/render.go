@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/m/internal/network"
+)
+
+// StatusColor returns the colour edges with the given status are drawn in.
+func StatusColor(status network.TrafficStatus) string {
+	switch status {
+	case network.StatusOK:
+		return "#2e7d32"
+	case network.StatusWarning:
+		return "#f9a825"
+	case network.StatusCritical:
+		return "#c62828"
+	default:
+		return "#9e9e9e"
+	}
+}
+
+// DOT renders the graph in Graphviz DOT format. Edges are labelled with
+// their flow count and coloured by status.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph services {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	for _, node := range g.Nodes {
+		attrs := fmt.Sprintf("label=%s", dotQuote(node.Label))
+		switch node.Kind {
+		case KindExternal:
+			attrs += ", shape=ellipse, style=dashed"
+		case KindUnknown:
+			attrs += ", style=\"rounded,dashed\""
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(node.ID), attrs)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, color=%s, fontcolor=%s];\n",
+			dotQuote(edge.Source), dotQuote(edge.Target), dotQuote(fmt.Sprint(edge.Flows)),
+			dotQuote(StatusColor(edge.Status)), dotQuote(StatusColor(edge.Status)))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart. Edges are labelled with
+// their flow count and coloured by status through linkStyle directives.
+func (g *Graph) Mermaid() string {
+	// Mermaid node IDs must be plain identifiers, so nodes are numbered.
+	ids := make(map[string]string, len(g.Nodes))
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, node := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[node.ID] = id
+		label := mermaidQuote(node.Label)
+		switch node.Kind {
+		case KindExternal:
+			fmt.Fprintf(&b, "  %s((%s))\n", id, label)
+		case KindUnknown:
+			fmt.Fprintf(&b, "  %s[/%s/]\n", id, label)
+		default:
+			fmt.Fprintf(&b, "  %s(%s)\n", id, label)
+		}
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -->|%d| %s\n", ids[edge.Source], edge.Flows, ids[edge.Target])
+	}
+	for i, edge := range g.Edges {
+		fmt.Fprintf(&b, "  linkStyle %d stroke:%s,color:%s\n", i, StatusColor(edge.Status), StatusColor(edge.Status))
+	}
+	return b.String()
+}
+
+// dotQuote returns s as a double-quoted DOT ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// mermaidQuote returns s as a double-quoted Mermaid label.
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,113 @@
+// Package inventory keeps the service inventory collection in step with the
+// services running in the Kubernetes cluster.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/service"
+	"github.com/rs/zerolog/log"
+)
+
+// ServiceLister lists the services running in the cluster and reports the
+// namespace and selectors it lists from.
+// *service.K8sServiceClient implements it.
+type ServiceLister interface {
+	GetAllServices() ([]service.ServiceData, error)
+	Scope() service.Scope
+}
+
+// Options says where the inventory lives and how often to refresh it.
+type Options struct {
+	Database          string
+	ServiceCollection string
+	// Interval between reconciliations after the first one. Zero
+	// reconciles once at startup only.
+	Interval time.Duration
+}
+
+// Result summarizes one reconciliation.
+type Result struct {
+	// Synced counts the cluster services written to the inventory.
+	Synced int
+	// Removed counts the inventory records newly marked as gone from the cluster.
+	Removed int
+}
+
+// Reconciler copies the cluster's services into the service inventory and
+// marks the records of services that no longer exist. Only records with
+// source service.SourceKubernetes inside the lister's scope are touched, so
+// services loaded by hand, or synced by a reconciler watching another
+// namespace or selector, are left alone.
+type Reconciler struct {
+	lister ServiceLister
+	store  database.ServiceRepository
+	opts   Options
+	now    func() time.Time
+}
+
+// NewReconciler creates a Reconciler writing to the given store.
+func NewReconciler(lister ServiceLister, store database.ServiceRepository, opts Options) *Reconciler {
+	return &Reconciler{lister: lister, store: store, opts: opts, now: time.Now}
+}
+
+// Reconcile runs a single reconciliation. Nothing is marked as removed when
+// the cluster cannot be listed.
+func (r *Reconciler) Reconcile(ctx context.Context) (*Result, error) {
+	services, err := r.lister.GetAllServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster services: %w", err)
+	}
+
+	now := r.now().UTC()
+	result := &Result{}
+	ids := make([]service.ServiceID, 0, len(services))
+	for _, svc := range services {
+		svc.Source = service.SourceKubernetes
+		svc.SyncedAt = &now
+		svc.RemovedAt = nil
+		if err := r.store.UpsertService(ctx, r.opts.Database, r.opts.ServiceCollection, svc); err != nil {
+			return result, err
+		}
+		ids = append(ids, svc.ID())
+		result.Synced++
+	}
+
+	result.Removed, err = r.store.MarkServicesRemoved(ctx, r.opts.Database, r.opts.ServiceCollection, service.SourceKubernetes, r.lister.Scope(), ids, now)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Run reconciles immediately and then every Interval until ctx is
+// cancelled. Failures are logged and retried on the next tick.
+func (r *Reconciler) Run(ctx context.Context) {
+	r.reconcileAndLog(ctx)
+	if r.opts.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcileAndLog(ctx)
+		}
+	}
+}
+
+func (r *Reconciler) reconcileAndLog(ctx context.Context) {
+	result, err := r.Reconcile(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("service inventory reconciliation failed")
+		return
+	}
+	log.Info().Int("synced", result.Synced).Int("removed", result.Removed).Msg("service inventory reconciled")
+}
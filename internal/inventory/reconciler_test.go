@@ -0,0 +1,227 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/service"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newService(name, clusterIP string, port int32) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Ports:     []v1.ServicePort{{Name: "https", Port: port, Protocol: v1.ProtocolTCP}},
+		},
+	}
+}
+
+func newEndpointSlice(service string, addresses ...string) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-abc",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: addresses}},
+	}
+}
+
+func findService(t *testing.T, services []service.ServiceData, name string) service.ServiceData {
+	t.Helper()
+	for _, svc := range services {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	t.Fatalf("service %s not found", name)
+	return service.ServiceData{}
+}
+
+func TestReconciler(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Database: "testdb", ServiceCollection: "services"}
+
+	clientset := fake.NewSimpleClientset(
+		newService("login", "10.96.0.10", 443),
+		newEndpointSlice("login", "10.128.72.69"),
+		newService("auth", "10.96.0.11", 443),
+	)
+	store := database.NewMemoryStore()
+	assert.NoError(t, store.InsertService(ctx, "testdb", "services", service.ServiceData{Name: "legacy", IPs: []string{"10.0.0.1"}}))
+
+	reconciler := NewReconciler(service.NewK8sServiceClient(clientset, "default"), store, opts)
+	now := time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC)
+	reconciler.now = func() time.Time { return now }
+
+	t.Run("SyncsClusterServices", func(t *testing.T) {
+		result, err := reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Synced: 2, Removed: 0}, result)
+
+		services, err := store.FindServices(ctx, "testdb", "services")
+		assert.NoError(t, err)
+		assert.Len(t, services, 3)
+
+		login := findService(t, services, "login")
+		assert.Equal(t, service.SourceKubernetes, login.Source)
+		assert.Equal(t, []string{"10.96.0.10", "10.128.72.69"}, login.IPs)
+		assert.Equal(t, now, *login.SyncedAt)
+		assert.Nil(t, login.RemovedAt)
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		_, err := reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+
+		services, err := store.FindServices(ctx, "testdb", "services")
+		assert.NoError(t, err)
+		assert.Len(t, services, 3)
+	})
+
+	t.Run("MarksRemovedServices", func(t *testing.T) {
+		assert.NoError(t, clientset.CoreV1().Services("default").Delete(ctx, "auth", metav1.DeleteOptions{}))
+		now = now.Add(time.Hour)
+
+		result, err := reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Synced: 1, Removed: 1}, result)
+
+		services, err := store.FindServices(ctx, "testdb", "services")
+		assert.NoError(t, err)
+		auth := findService(t, services, "auth")
+		if assert.NotNil(t, auth.RemovedAt) {
+			assert.Equal(t, now, *auth.RemovedAt)
+		}
+		assert.Nil(t, findService(t, services, "login").RemovedAt)
+		assert.Nil(t, findService(t, services, "legacy").RemovedAt)
+
+		// A service already marked is not counted again.
+		result, err = reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result.Removed)
+	})
+
+	t.Run("ReturningServiceIsUnmarked", func(t *testing.T) {
+		_, err := clientset.CoreV1().Services("default").Create(ctx, newService("auth", "10.96.0.11", 443), metav1.CreateOptions{})
+		assert.NoError(t, err)
+
+		_, err = reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+
+		services, err := store.FindServices(ctx, "testdb", "services")
+		assert.NoError(t, err)
+		assert.Len(t, services, 3)
+		assert.Nil(t, findService(t, services, "auth").RemovedAt)
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		store := database.NewMemoryStore()
+		reconciler := NewReconciler(service.NewK8sServiceClient(clientset, "default"), store, Options{
+			Database:          "testdb",
+			ServiceCollection: "services",
+			Interval:          10 * time.Millisecond,
+		})
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			reconciler.Run(ctx)
+			close(done)
+		}()
+
+		assert.Eventually(t, func() bool {
+			services, _ := store.FindServices(ctx, "testdb", "services")
+			return len(services) == 2
+		}, time.Second, 5*time.Millisecond)
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Run did not return after the context was cancelled")
+		}
+	})
+}
+
+func TestReconcilerNamespaces(t *testing.T) {
+	ctx := context.Background()
+	prod := newService("auth", "10.96.0.10", 443)
+	prod.Namespace = "prod"
+	staging := newService("auth", "10.96.1.10", 443)
+	staging.Namespace = "staging"
+	clientset := fake.NewSimpleClientset(prod, staging)
+
+	store := database.NewMemoryStore()
+	reconciler := NewReconciler(service.NewK8sServiceClient(clientset, metav1.NamespaceAll), store, Options{Database: "testdb", ServiceCollection: "services"})
+
+	result, err := reconciler.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Synced: 2, Removed: 0}, result)
+
+	assert.NoError(t, clientset.CoreV1().Services("staging").Delete(ctx, "auth", metav1.DeleteOptions{}))
+	result, err = reconciler.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Synced: 1, Removed: 1}, result)
+
+	services, err := store.FindServices(ctx, "testdb", "services")
+	assert.NoError(t, err)
+	if assert.Len(t, services, 2) {
+		for _, svc := range services {
+			assert.Equal(t, svc.Namespace == "staging", svc.RemovedAt != nil, svc.ID().String())
+		}
+	}
+}
+
+func TestReconcilerScope(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Database: "testdb", ServiceCollection: "services"}
+	prod := newService("auth", "10.96.0.10", 443)
+	prod.Namespace = "prod"
+	staging := newService("auth", "10.96.1.10", 443)
+	staging.Namespace = "staging"
+	frontend := newService("web", "10.96.0.20", 443)
+	frontend.Namespace = "prod"
+	frontend.Labels = map[string]string{"tier": "frontend"}
+	prod.Labels = map[string]string{"tier": "backend"}
+	clientset := fake.NewSimpleClientset(prod, staging, frontend)
+
+	store := database.NewMemoryStore()
+	result, err := NewReconciler(service.NewK8sServiceClient(clientset, metav1.NamespaceAll), store, opts).Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Synced)
+
+	t.Run("Namespace", func(t *testing.T) {
+		result, err := NewReconciler(service.NewK8sServiceClient(clientset, "staging"), store, opts).Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Synced: 1, Removed: 0}, result, "services in other namespaces are out of scope")
+	})
+
+	t.Run("Selectors", func(t *testing.T) {
+		client := service.NewK8sServiceClient(clientset, "prod").WithSelectors(service.Selectors{Label: "tier=backend"})
+		reconciler := NewReconciler(client, store, opts)
+		result, err := reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Synced: 1, Removed: 0}, result, "services the selector excludes are out of scope")
+
+		assert.NoError(t, clientset.CoreV1().Services("prod").Delete(ctx, "auth", metav1.DeleteOptions{}))
+		result, err = reconciler.Reconcile(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &Result{Synced: 0, Removed: 1}, result)
+
+		services, err := store.FindServices(ctx, "testdb", "services")
+		assert.NoError(t, err)
+		for _, svc := range services {
+			assert.Equal(t, svc.ID() == service.ServiceID{Namespace: "prod", Name: "auth"}, svc.RemovedAt != nil, svc.ID().String())
+		}
+	})
+}
@@ -1,11 +1,28 @@
 package network
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
 type NetworkTraffic struct {
-	SourceIP        string        `bson:"source_ip"`
-	SourcePort      int           `bson:"source_port"`
-	DestinationIP   string        `bson:"destination_ip"`
-	DestinationPort int           `bson:"destination_port"`
-	Status          TrafficStatus `bson:"status"` // Custom type for status
+	SourceIP        string        `bson:"source_ip" json:"source_ip"`
+	SourcePort      int           `bson:"source_port" json:"source_port"`
+	DestinationIP   string        `bson:"destination_ip" json:"destination_ip"`
+	DestinationPort int           `bson:"destination_port" json:"destination_port"`
+	Status          TrafficStatus `bson:"status" json:"status"` // Custom type for status
+	Protocol        string        `bson:"protocol,omitempty" json:"protocol,omitempty"`
+	ObservedAt      *time.Time    `bson:"observed_at,omitempty" json:"observed_at,omitempty"`
+	DurationMillis  int64         `bson:"duration_ms,omitempty" json:"duration_ms,omitempty"`
+	Bytes           int64         `bson:"bytes,omitempty" json:"bytes,omitempty"`
+	Packets         int64         `bson:"packets,omitempty" json:"packets,omitempty"`
 }
 
 type TrafficStatus string
@@ -15,3 +32,165 @@ const (
 	StatusWarning  TrafficStatus = "Warning"
 	StatusCritical TrafficStatus = "Critical"
 )
+
+// ErrInvalidStatus is returned for a traffic status other than OK, Warning
+// or Critical.
+var ErrInvalidStatus = errors.New("invalid traffic status")
+
+// ParseTrafficStatus returns the status named by s, ignoring case, so "ok"
+// and "CRITICAL" become StatusOK and StatusCritical.
+func ParseTrafficStatus(s string) (TrafficStatus, error) {
+	for _, status := range []TrafficStatus{StatusOK, StatusWarning, StatusCritical} {
+		if strings.EqualFold(s, string(status)) {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q: must be OK, Warning or Critical", ErrInvalidStatus, s)
+}
+
+// IsValid reports whether s names one of the known statuses, in any case,
+// as ParseTrafficStatus does.
+func (s TrafficStatus) IsValid() bool {
+	_, err := ParseTrafficStatus(string(s))
+	return err == nil
+}
+
+// canonical returns the known status s names in its canonical spelling, or
+// s unchanged when it is unknown.
+func (s TrafficStatus) canonical() TrafficStatus {
+	if status, err := ParseTrafficStatus(string(s)); err == nil {
+		return status
+	}
+	return s
+}
+
+// MarshalJSON writes a known status in its canonical spelling and, like
+// MarshalBSONValue, refuses to write an unknown one.
+func (s TrafficStatus) MarshalJSON() ([]byte, error) {
+	if !s.IsValid() {
+		return nil, fmt.Errorf("%w %q", ErrInvalidStatus, string(s))
+	}
+	return json.Marshal(string(s.canonical()))
+}
+
+// UnmarshalJSON accepts a known status in any case and stores its canonical spelling.
+func (s *TrafficStatus) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidStatus, data)
+	}
+	status, err := ParseTrafficStatus(value)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// MarshalBSONValue refuses to encode an unknown status, so one can never be
+// stored, and stores known ones in their canonical spelling.
+func (s TrafficStatus) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if !s.IsValid() {
+		return 0, nil, fmt.Errorf("%w %q", ErrInvalidStatus, string(s))
+	}
+	return bson.MarshalValue(string(s.canonical()))
+}
+
+// UnmarshalBSONValue reads a known status in any case in its canonical
+// spelling. Unknown strings are kept as they are and a null status reads as
+// empty, so documents written before validation existed can still be read;
+// IsValid reports them. Only a status that is not a string fails to decode.
+func (s *TrafficStatus) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	if t == bsontype.Null || t == bsontype.Undefined {
+		*s = ""
+		return nil
+	}
+	value, ok := raw.StringValueOK()
+	if !ok {
+		return fmt.Errorf("%w: expected a string, got BSON %s", ErrInvalidStatus, t)
+	}
+	*s = TrafficStatus(value).canonical()
+	return nil
+}
+
+// ParseProtocol returns the transport protocol named by s in upper case, so
+// "tcp" becomes "TCP". An empty string is returned unchanged, as records and
+// filters may leave the protocol out.
+func ParseProtocol(s string) (string, error) {
+	switch protocol := strings.ToUpper(s); protocol {
+	case "", "TCP", "UDP", "SCTP":
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("invalid protocol %q: must be TCP, UDP or SCTP", s)
+	}
+}
+
+// Normalize rewrites the record's status and protocol in their canonical
+// spelling, so records can be matched on them exactly once stored. Unknown
+// values are left for Validate to reject.
+func (t *NetworkTraffic) Normalize() {
+	t.Status = t.Status.canonical()
+	if protocol, err := ParseProtocol(t.Protocol); err == nil {
+		t.Protocol = protocol
+	}
+}
+
+// ObservedTime returns when the flow was observed, or the zero time for a
+// record without an observed_at.
+func (t NetworkTraffic) ObservedTime() time.Time {
+	if t.ObservedAt == nil {
+		return time.Time{}
+	}
+	return *t.ObservedAt
+}
+
+// Validate checks that the record's addresses are valid IPs, its ports are
+// between 0 and 65535, and its status and protocol are known.
+func (t NetworkTraffic) Validate() error {
+	if _, err := netip.ParseAddr(t.SourceIP); err != nil {
+		return fmt.Errorf("invalid source_ip %q", t.SourceIP)
+	}
+	if _, err := netip.ParseAddr(t.DestinationIP); err != nil {
+		return fmt.Errorf("invalid destination_ip %q", t.DestinationIP)
+	}
+	if t.SourcePort < 0 || t.SourcePort > 65535 {
+		return fmt.Errorf("invalid source_port %d: must be between 0 and 65535", t.SourcePort)
+	}
+	if t.DestinationPort < 0 || t.DestinationPort > 65535 {
+		return fmt.Errorf("invalid destination_port %d: must be between 0 and 65535", t.DestinationPort)
+	}
+	if !t.Status.IsValid() {
+		return fmt.Errorf("%w %q: must be OK, Warning or Critical", ErrInvalidStatus, string(t.Status))
+	}
+	if _, err := ParseProtocol(t.Protocol); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Severity ranks a status so the worst of several can be picked. Unknown
+// statuses rank below StatusOK.
+func (s TrafficStatus) Severity() int {
+	switch s {
+	case StatusOK:
+		return 1
+	case StatusWarning:
+		return 2
+	case StatusCritical:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// WorstStatus returns the most severe of the given statuses.
+func WorstStatus(statuses ...TrafficStatus) TrafficStatus {
+	var worst TrafficStatus
+	for _, status := range statuses {
+		if worst == "" || status.Severity() > worst.Severity() {
+			worst = status
+		}
+	}
+	return worst
+}
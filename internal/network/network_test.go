@@ -0,0 +1,122 @@
+package network
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTrafficStatus(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		for input, expected := range map[string]TrafficStatus{"OK": StatusOK, "ok": StatusOK, "CRITICAL": StatusCritical, "warning": StatusWarning} {
+			status, err := ParseTrafficStatus(input)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, status)
+		}
+		for _, input := range []string{"", "Okay", "garbage"} {
+			_, err := ParseTrafficStatus(input)
+			assert.ErrorIs(t, err, ErrInvalidStatus, input)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var record NetworkTraffic
+		assert.NoError(t, json.Unmarshal([]byte(`{"status": "critical"}`), &record))
+		assert.Equal(t, StatusCritical, record.Status)
+
+		assert.ErrorIs(t, json.Unmarshal([]byte(`{"status": "broken"}`), &record), ErrInvalidStatus)
+		assert.ErrorIs(t, json.Unmarshal([]byte(`{"status": 3}`), &record), ErrInvalidStatus)
+
+		data, err := json.Marshal(TrafficStatus("ok"))
+		assert.NoError(t, err)
+		assert.Equal(t, `"OK"`, string(data))
+		for _, status := range []TrafficStatus{"", "broken"} {
+			_, err := json.Marshal(status)
+			assert.ErrorIs(t, err, ErrInvalidStatus, status)
+		}
+	})
+
+	t.Run("BSON", func(t *testing.T) {
+		data, err := bson.Marshal(bson.D{{Key: "status", Value: "WARNING"}})
+		assert.NoError(t, err)
+		var record NetworkTraffic
+		assert.NoError(t, bson.Unmarshal(data, &record))
+		assert.Equal(t, StatusWarning, record.Status)
+
+		data, err = bson.Marshal(bson.D{{Key: "status", Value: "broken"}})
+		assert.NoError(t, err)
+		assert.NoError(t, bson.Unmarshal(data, &record), "stored documents stay readable")
+		assert.Equal(t, TrafficStatus("broken"), record.Status)
+		assert.False(t, record.Status.IsValid())
+
+		data, err = bson.Marshal(bson.D{{Key: "status", Value: nil}})
+		assert.NoError(t, err)
+		assert.NoError(t, bson.Unmarshal(data, &record))
+		assert.Equal(t, TrafficStatus(""), record.Status)
+
+		data, err = bson.Marshal(bson.D{{Key: "status", Value: 3}})
+		assert.NoError(t, err)
+		assert.ErrorIs(t, bson.Unmarshal(data, &record), ErrInvalidStatus)
+
+		data, err = bson.Marshal(NetworkTraffic{Status: "ok"})
+		assert.NoError(t, err)
+		assert.Equal(t, "OK", bson.Raw(data).Lookup("status").StringValue())
+
+		_, err = bson.Marshal(NetworkTraffic{Status: "broken"})
+		assert.ErrorIs(t, err, ErrInvalidStatus)
+	})
+
+	t.Run("IsValid", func(t *testing.T) {
+		for _, status := range []TrafficStatus{"OK", "ok", "WARNING", "Critical"} {
+			assert.True(t, status.IsValid(), status)
+		}
+		for _, status := range []TrafficStatus{"", "Okay", "garbage"} {
+			assert.False(t, status.IsValid(), status)
+		}
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	record := NetworkTraffic{Status: "critical", Protocol: "udp"}
+	record.Normalize()
+	assert.Equal(t, StatusCritical, record.Status)
+	assert.Equal(t, "UDP", record.Protocol)
+
+	record.Protocol = "icmp"
+	record.Normalize()
+	assert.Equal(t, "icmp", record.Protocol, "unknown protocols are left for Validate")
+}
+
+func TestObservedAt(t *testing.T) {
+	data, err := json.Marshal(NetworkTraffic{Status: StatusOK})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "observed_at", "a record without a time has none in its JSON")
+
+	var record NetworkTraffic
+	assert.NoError(t, json.Unmarshal([]byte(`{"observed_at": "2024-11-20T09:00:00Z"}`), &record))
+	assert.Equal(t, time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC), record.ObservedTime())
+	assert.True(t, NetworkTraffic{}.ObservedTime().IsZero())
+}
+
+func TestValidate(t *testing.T) {
+	valid := NetworkTraffic{SourceIP: "10.0.0.1", SourcePort: 0, DestinationIP: "10.0.0.2", DestinationPort: 65535, Status: StatusOK}
+	assert.NoError(t, valid.Validate())
+
+	for name, mutate := range map[string]func(*NetworkTraffic){
+		"SourceIP":        func(r *NetworkTraffic) { r.SourceIP = "10.0.0" },
+		"DestinationIP":   func(r *NetworkTraffic) { r.DestinationIP = "" },
+		"SourcePort":      func(r *NetworkTraffic) { r.SourcePort = -1 },
+		"DestinationPort": func(r *NetworkTraffic) { r.DestinationPort = 65536 },
+		"Status":          func(r *NetworkTraffic) { r.Status = "okay" },
+		"Protocol":        func(r *NetworkTraffic) { r.Protocol = "ICMP" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := valid
+			mutate(&record)
+			assert.Error(t, record.Validate())
+		})
+	}
+}
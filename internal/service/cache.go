@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ipIndex indexes Services by cluster IP and EndpointSlices by endpoint address.
+const ipIndex = "ip"
+
+// ErrCacheNotSynced is reported until the cache has completed its initial list.
+var ErrCacheNotSynced = errors.New("service cache has not synced")
+
+// ServiceCache is a watch-driven, in-memory view of the cluster's Services
+// and EndpointSlices. Lookups are answered from shared informers instead of
+// calling the API server, and the IP indexes are kept current by watch events.
+type ServiceCache struct {
+	services       cache.SharedIndexInformer
+	slices         cache.SharedIndexInformer
+	serviceLister  corelisters.ServiceLister
+	endpointSlices discoverylisters.EndpointSliceLister
+}
+
+// NewServiceCache creates a cache of the services in namespace that match
+// selectors, reading from informers of factory, which must cover the same
+// namespace; an empty namespace watches every namespace. The cache fills
+// once the factory is started.
+func NewServiceCache(factory informers.SharedInformerFactory, namespace string, selectors Selectors) (*ServiceCache, error) {
+	// The selectors apply to Services only; endpoint slices are matched to
+	// the cached services by their service-name label.
+	services := factory.InformerFor(&v1.Service{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredServiceInformer(client, namespace, resync,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, selectors.listOptions)
+	})
+	slices := factory.Discovery().V1().EndpointSlices()
+
+	if err := addIndexer(services, ipIndex, serviceIPs); err != nil {
+		return nil, fmt.Errorf("failed to index services: %v", err)
+	}
+	if err := addIndexer(slices.Informer(), ipIndex, endpointSliceIPs); err != nil {
+		return nil, fmt.Errorf("failed to index endpoint slices: %v", err)
+	}
+
+	return &ServiceCache{
+		services:       services,
+		slices:         slices.Informer(),
+		serviceLister:  corelisters.NewServiceLister(services.GetIndexer()),
+		endpointSlices: slices.Lister(),
+	}, nil
+}
+
+// addIndexer adds an index to a shared informer unless it already has one
+// under that name, as the EndpointSlice informer shared by the service cache
+// and the pod resolver does once either has indexed it.
+func addIndexer(informer cache.SharedIndexInformer, name string, fn cache.IndexFunc) error {
+	if _, ok := informer.GetIndexer().GetIndexers()[name]; ok {
+		return nil
+	}
+	return informer.AddIndexers(cache.Indexers{name: fn})
+}
+
+// WaitForSync blocks until the initial list has been loaded or ctx is done,
+// and reports whether the cache synced.
+func (c *ServiceCache) WaitForSync(ctx context.Context) bool {
+	return cache.WaitForCacheSync(ctx.Done(), c.HasSynced)
+}
+
+// HasSynced reports whether the initial list of Services and EndpointSlices has been loaded.
+func (c *ServiceCache) HasSynced() bool {
+	return c.services.HasSynced() && c.slices.HasSynced()
+}
+
+// Ready returns ErrCacheNotSynced until the cache has synced, for use in readiness checks.
+func (c *ServiceCache) Ready() error {
+	if !c.HasSynced() {
+		return ErrCacheNotSynced
+	}
+	return nil
+}
+
+// LookupIP returns the service with the given cluster IP or endpoint address.
+func (c *ServiceCache) LookupIP(ip string) (*ServiceData, bool) {
+	if objs, err := c.services.GetIndexer().ByIndex(ipIndex, ip); err == nil && len(objs) > 0 {
+		data := c.serviceData(objs[0].(*v1.Service))
+		return &data, true
+	}
+
+	objs, err := c.slices.GetIndexer().ByIndex(ipIndex, ip)
+	if err != nil {
+		return nil, false
+	}
+	for _, obj := range objs {
+		slice := obj.(*discoveryv1.EndpointSlice)
+		name, ok := slice.Labels[discoveryv1.LabelServiceName]
+		if !ok {
+			continue
+		}
+		svc, err := c.serviceLister.Services(slice.Namespace).Get(name)
+		if err != nil {
+			continue
+		}
+		data := c.serviceData(svc)
+		return &data, true
+	}
+	return nil, false
+}
+
+// Services returns every cached service.
+func (c *ServiceCache) Services() ([]ServiceData, error) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cached services: %v", err)
+	}
+	var result []ServiceData
+	for _, svc := range services {
+		result = append(result, c.serviceData(svc))
+	}
+	return result, nil
+}
+
+// NamespaceIPs returns the cluster IPs and endpoint addresses of the cached
+// services in namespace.
+func (c *ServiceCache) NamespaceIPs(namespace string) []string {
+	services, err := c.serviceLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, svc := range services {
+		ips = append(ips, c.serviceData(svc).IPs...)
+	}
+	return ips
+}
+
+// serviceData combines a cached service with its cached endpoint slices.
+func (c *ServiceCache) serviceData(svc *v1.Service) ServiceData {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: svc.Name})
+	slices, _ := c.endpointSlices.EndpointSlices(svc.Namespace).List(selector)
+	items := make([]discoveryv1.EndpointSlice, 0, len(slices))
+	for _, slice := range slices {
+		items = append(items, *slice)
+	}
+	return serviceDataFrom(svc, items)
+}
+
+// serviceIPs is the index function listing a Service's cluster IPs.
+func serviceIPs(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return nil, nil
+	}
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+	var ips []string
+	for _, ip := range clusterIPs {
+		if ip != "" && ip != v1.ClusterIPNone {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// endpointSliceIPs is the index function listing an EndpointSlice's endpoint addresses.
+func endpointSliceIPs(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, endpoint := range slice.Endpoints {
+		ips = append(ips, endpoint.Addresses...)
+	}
+	return ips, nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServiceCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	portName, targetPort, protocol := "https", int32(8443), v1.ProtocolTCP
+	clientset := fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "login", Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				ClusterIP: "10.96.0.10",
+				Ports:     []v1.ServicePort{{Name: "https", Port: 443, Protocol: v1.ProtocolTCP}},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "login-abc",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "login"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.128.72.69"}}},
+			Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &targetPort, Protocol: &protocol}},
+		},
+	)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace("default"))
+	cache, err := NewServiceCache(factory, "default", Selectors{})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, cache.Ready(), ErrCacheNotSynced)
+
+	factory.Start(ctx.Done())
+	assert.True(t, cache.WaitForSync(ctx))
+	assert.NoError(t, cache.Ready())
+
+	t.Run("LookupClusterIP", func(t *testing.T) {
+		svc, ok := cache.LookupIP("10.96.0.10")
+		if assert.True(t, ok) {
+			assert.Equal(t, "login", svc.Name)
+			assert.Equal(t, []string{"10.96.0.10", "10.128.72.69"}, svc.IPs)
+		}
+	})
+
+	t.Run("LookupEndpointIP", func(t *testing.T) {
+		svc, ok := cache.LookupIP("10.128.72.69")
+		if assert.True(t, ok) {
+			assert.Equal(t, "login", svc.Name)
+			if port := svc.PortFor("10.128.72.69", 8443); assert.NotNil(t, port) {
+				assert.Equal(t, "https", port.Name)
+			}
+			assert.Nil(t, svc.PortFor("10.128.72.69", 443))
+			assert.NotNil(t, svc.PortFor("10.96.0.10", 443))
+		}
+
+		_, ok = cache.LookupIP("10.0.0.1")
+		assert.False(t, ok)
+	})
+
+	t.Run("NamespaceIPs", func(t *testing.T) {
+		assert.Equal(t, []string{"10.96.0.10", "10.128.72.69"}, cache.NamespaceIPs("default"))
+		assert.Empty(t, cache.NamespaceIPs("staging"))
+	})
+
+	t.Run("FollowsWatchEvents", func(t *testing.T) {
+		_, err := clientset.CoreV1().Services("default").Create(ctx, &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: "default"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.96.0.11"},
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			_, ok := cache.LookupIP("10.96.0.11")
+			return ok
+		}, time.Second, 5*time.Millisecond)
+
+		services, err := cache.Services()
+		assert.NoError(t, err)
+		assert.Len(t, services, 2)
+
+		assert.NoError(t, clientset.CoreV1().Services("default").Delete(ctx, "auth", metav1.DeleteOptions{}))
+		assert.Eventually(t, func() bool {
+			_, ok := cache.LookupIP("10.96.0.11")
+			return !ok
+		}, time.Second, 5*time.Millisecond)
+	})
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// FieldManager is the field manager recorded for server-side applies
+const FieldManager = "thoras-server"
+
+// ServiceSpec describes the desired state of a service for
+// CreateServiceFromSpec, UpdateService and Apply
+type ServiceSpec struct {
+	Name string
+	// Namespace defaults to the client's namespace
+	Namespace string
+	// Type defaults to ClusterIP
+	Type v1.ServiceType
+	// Headless creates a ClusterIP service without a cluster IP. It cannot
+	// be changed once the service exists.
+	Headless bool
+	// ExternalName is the DNS name an ExternalName service points to
+	ExternalName string
+	// Selector picks the pods backing the service. A nil selector defaults
+	// to app=<name>; an empty one selects no pods.
+	Selector    map[string]string
+	Labels      map[string]string
+	Annotations map[string]string
+	Ports       []PortSpec
+}
+
+// PortSpec is a single port of a ServiceSpec
+type PortSpec struct {
+	// Name is required when the service has more than one port
+	Name string
+	Port int32
+	// TargetPort is the pod port, by number or name; it defaults to Port
+	TargetPort intstr.IntOrString
+	// Protocol defaults to TCP
+	Protocol v1.Protocol
+	// NodePort requests a specific node port for NodePort and LoadBalancer
+	// services; zero lets the cluster allocate one
+	NodePort int32
+}
+
+// Validate checks that the spec describes a service the API server accepts
+func (s ServiceSpec) Validate() error {
+	if s.Name == "" {
+		return errors.New("service name is required")
+	}
+
+	switch s.serviceType() {
+	case v1.ServiceTypeClusterIP:
+	case v1.ServiceTypeNodePort, v1.ServiceTypeLoadBalancer:
+		if s.Headless {
+			return fmt.Errorf("a %s service cannot be headless", s.Type)
+		}
+	case v1.ServiceTypeExternalName:
+		if s.ExternalName == "" {
+			return errors.New("an ExternalName service needs an external name")
+		}
+		if s.Headless {
+			return errors.New("an ExternalName service cannot be headless")
+		}
+	default:
+		return fmt.Errorf("unsupported service type %q", s.Type)
+	}
+	if s.ExternalName != "" && s.serviceType() != v1.ServiceTypeExternalName {
+		return fmt.Errorf("an external name is only valid for ExternalName services, not %s", s.serviceType())
+	}
+	if len(s.Ports) == 0 && s.serviceType() != v1.ServiceTypeExternalName && !s.Headless {
+		return fmt.Errorf("a %s service needs at least one port", s.serviceType())
+	}
+
+	names := make(map[string]bool, len(s.Ports))
+	for _, port := range s.Ports {
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("port %d is out of range", port.Port)
+		}
+		if len(s.Ports) > 1 && port.Name == "" {
+			return fmt.Errorf("port %d needs a name when a service has several ports", port.Port)
+		}
+		if names[port.Name] {
+			return fmt.Errorf("duplicate port name %q", port.Name)
+		}
+		names[port.Name] = true
+
+		switch port.protocol() {
+		case v1.ProtocolTCP, v1.ProtocolUDP, v1.ProtocolSCTP:
+		default:
+			return fmt.Errorf("unsupported protocol %q on port %d", port.Protocol, port.Port)
+		}
+		if port.NodePort != 0 && s.serviceType() != v1.ServiceTypeNodePort && s.serviceType() != v1.ServiceTypeLoadBalancer {
+			return fmt.Errorf("port %d requests a node port, which only NodePort and LoadBalancer services have", port.Port)
+		}
+	}
+	return nil
+}
+
+func (s ServiceSpec) serviceType() v1.ServiceType {
+	if s.Type == "" {
+		return v1.ServiceTypeClusterIP
+	}
+	return s.Type
+}
+
+func (s ServiceSpec) selector() map[string]string {
+	if s.serviceType() == v1.ServiceTypeExternalName {
+		return nil
+	}
+	if s.Selector == nil {
+		return map[string]string{"app": s.Name}
+	}
+	return s.Selector
+}
+
+func (p PortSpec) protocol() v1.Protocol {
+	if p.Protocol == "" {
+		return v1.ProtocolTCP
+	}
+	return p.Protocol
+}
+
+func (p PortSpec) targetPort() intstr.IntOrString {
+	if p.TargetPort.Type == intstr.String && p.TargetPort.StrVal == "" ||
+		p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal == 0 {
+		return intstr.FromInt32(p.Port)
+	}
+	return p.TargetPort
+}
+
+// servicePorts converts the spec's ports, keeping the node ports already
+// allocated in existing for ports that do not request one
+func (s ServiceSpec) servicePorts(existing []v1.ServicePort) []v1.ServicePort {
+	var ports []v1.ServicePort
+	for _, port := range s.Ports {
+		servicePort := v1.ServicePort{
+			Name:       port.Name,
+			Port:       port.Port,
+			TargetPort: port.targetPort(),
+			Protocol:   port.protocol(),
+			NodePort:   port.NodePort,
+		}
+		if servicePort.NodePort == 0 && (s.serviceType() == v1.ServiceTypeNodePort || s.serviceType() == v1.ServiceTypeLoadBalancer) {
+			for _, current := range existing {
+				if current.Name == servicePort.Name && current.Port == servicePort.Port && current.Protocol == servicePort.Protocol {
+					servicePort.NodePort = current.NodePort
+				}
+			}
+		}
+		ports = append(ports, servicePort)
+	}
+	return ports
+}
+
+// namespaceFor returns the namespace a spec is written to
+func (k *K8sServiceClient) namespaceFor(namespace string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	if k.AllNamespaces() {
+		return "", errors.New("a namespace is required when the client watches all namespaces")
+	}
+	return k.namespace, nil
+}
+
+// CreateService creates a ClusterIP service selecting app=<name> with the
+// service data's ports, in its namespace or the client's when it has none
+func (k *K8sServiceClient) CreateService(serviceData ServiceData) (*v1.Service, error) {
+	return k.CreateServiceFromSpec(specFromData(serviceData))
+}
+
+// specFromData converts service data to the spec CreateService creates
+func specFromData(serviceData ServiceData) ServiceSpec {
+	spec := ServiceSpec{Name: serviceData.Name, Namespace: serviceData.Namespace}
+	for _, port := range serviceData.Ports {
+		spec.Ports = append(spec.Ports, PortSpec{
+			Name:     port.Name,
+			Port:     port.Port,
+			Protocol: v1.Protocol(port.Protocol),
+		})
+	}
+	return spec
+}
+
+// CreateServiceFromSpec creates a service from spec
+func (k *K8sServiceClient) CreateServiceFromSpec(spec ServiceSpec) (*v1.Service, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid service %s: %v", spec.Name, err)
+	}
+	namespace, err := k.namespaceFor(spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: spec.Name, Namespace: namespace}}
+	applySpec(service, spec)
+
+	// Retry logic in case of transient failures
+	var createdService *v1.Service
+	err = retry.OnError(retry.DefaultRetry, isRetryableError, func() error {
+		var err error
+		createdService, err = k.clientset.CoreV1().Services(namespace).Create(context.Background(), service, metav1.CreateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service after retries: %v", err)
+	}
+
+	return createdService, nil
+}
+
+// UpdateService replaces the existing service's type, selector and ports
+// with those in spec. The spec's labels and annotations are merged into the
+// existing ones, so keys set by other tools are kept and a key can be
+// changed but not removed. The cluster IP is kept, so a service cannot be
+// switched to or from headless. The update is retried when another writer
+// changed the service in the meantime.
+func (k *K8sServiceClient) UpdateService(spec ServiceSpec) (*v1.Service, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid service %s: %v", spec.Name, err)
+	}
+	namespace, err := k.namespaceFor(spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	services := k.clientset.CoreV1().Services(namespace)
+	var updatedService *v1.Service
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := services.Get(context.Background(), spec.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		switching := current.Spec.Type == v1.ServiceTypeExternalName || spec.serviceType() == v1.ServiceTypeExternalName
+		if headless := current.Spec.ClusterIP == v1.ClusterIPNone; headless != spec.Headless && !switching {
+			return fmt.Errorf("service %s/%s cannot be switched to or from headless", namespace, spec.Name)
+		}
+
+		desired := current.DeepCopy()
+		applySpec(desired, spec)
+		updatedService, err = services.Update(context.Background(), desired, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update service: %v", err)
+	}
+
+	return updatedService, nil
+}
+
+// DeleteService deletes the service with the given name from namespace, or
+// from the client's namespace when it is empty. Deleting a service that
+// does not exist is not an error.
+func (k *K8sServiceClient) DeleteService(namespace, serviceName string) error {
+	namespace, err := k.namespaceFor(namespace)
+	if err != nil {
+		return err
+	}
+
+	err = retry.OnError(retry.DefaultRetry, isRetryableError, func() error {
+		return k.clientset.CoreV1().Services(namespace).Delete(context.Background(), serviceName, metav1.DeleteOptions{})
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service: %v", err)
+	}
+	return nil
+}
+
+// ApplyOptions controls how Apply resolves ownership conflicts
+type ApplyOptions struct {
+	// Force takes over fields owned by other field managers. Without it,
+	// changing such a field fails with a conflict.
+	Force bool
+}
+
+// Apply creates or updates the service with server-side apply, owning the
+// fields set in spec as FieldManager. Fields the spec no longer sets are
+// removed if this manager set them before. When the spec changes a field
+// another manager owns, Apply fails with an error satisfying
+// apierrors.IsConflict unless opts.Force is set; conflicts are not retried.
+func (k *K8sServiceClient) Apply(spec ServiceSpec, opts ApplyOptions) (*v1.Service, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid service %s: %v", spec.Name, err)
+	}
+	namespace, err := k.namespaceFor(spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	config := applyConfiguration(namespace, spec)
+	var appliedService *v1.Service
+	err = retry.OnError(retry.DefaultRetry, isRetryableError, func() error {
+		var err error
+		appliedService, err = k.clientset.CoreV1().Services(namespace).Apply(context.Background(), config, metav1.ApplyOptions{
+			FieldManager: FieldManager,
+			Force:        opts.Force,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply service: %w", err)
+	}
+
+	return appliedService, nil
+}
+
+// applySpec sets the fields of service managed by a ServiceSpec. Labels and
+// annotations are merged into the ones the service already has.
+func applySpec(service *v1.Service, spec ServiceSpec) {
+	service.Labels = mergeStrings(service.Labels, spec.Labels)
+	service.Annotations = mergeStrings(service.Annotations, spec.Annotations)
+
+	service.Spec.Type = spec.serviceType()
+	service.Spec.Selector = spec.selector()
+	service.Spec.Ports = spec.servicePorts(service.Spec.Ports)
+	service.Spec.ExternalName = spec.ExternalName
+	switch {
+	case spec.serviceType() == v1.ServiceTypeExternalName:
+		service.Spec.ClusterIP = ""
+		service.Spec.ClusterIPs = nil
+	case spec.Headless:
+		service.Spec.ClusterIP = v1.ClusterIPNone
+	}
+}
+
+// mergeStrings returns current with the entries of set added or replaced,
+// leaving current itself untouched
+func mergeStrings(current, set map[string]string) map[string]string {
+	if len(set) == 0 {
+		return current
+	}
+	merged := make(map[string]string, len(current)+len(set))
+	maps.Copy(merged, current)
+	maps.Copy(merged, set)
+	return merged
+}
+
+// applyConfiguration builds the server-side apply request for spec
+func applyConfiguration(namespace string, spec ServiceSpec) *corev1ac.ServiceApplyConfiguration {
+	serviceSpec := corev1ac.ServiceSpec().WithType(spec.serviceType())
+	if selector := spec.selector(); len(selector) > 0 {
+		serviceSpec.WithSelector(selector)
+	}
+	if spec.ExternalName != "" {
+		serviceSpec.WithExternalName(spec.ExternalName)
+	}
+	if spec.Headless {
+		serviceSpec.WithClusterIP(v1.ClusterIPNone)
+	}
+	for _, port := range spec.Ports {
+		servicePort := corev1ac.ServicePort().
+			WithName(port.Name).
+			WithPort(port.Port).
+			WithTargetPort(port.targetPort()).
+			WithProtocol(port.protocol())
+		if port.NodePort != 0 {
+			servicePort.WithNodePort(port.NodePort)
+		}
+		serviceSpec.WithPorts(servicePort)
+	}
+
+	config := corev1ac.Service(spec.Name, namespace).WithSpec(serviceSpec)
+	if len(spec.Labels) > 0 {
+		config.WithLabels(spec.Labels)
+	}
+	if len(spec.Annotations) > 0 {
+		config.WithAnnotations(spec.Annotations)
+	}
+	return config
+}
+
+// isRetryableError reports whether a request failed for a transient reason
+// worth retrying, such as a timeout or the API server being overloaded
+func isRetryableError(err error) bool {
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServiceSpecValidate(t *testing.T) {
+	valid := ServiceSpec{Name: "api", Ports: []PortSpec{{Port: 80}}}
+	assert.NoError(t, valid.Validate())
+
+	tests := map[string]ServiceSpec{
+		"MissingName":               {Ports: []PortSpec{{Port: 80}}},
+		"NoPorts":                   {Name: "api"},
+		"UnnamedPorts":              {Name: "api", Ports: []PortSpec{{Port: 80}, {Port: 443}}},
+		"DuplicatePortNames":        {Name: "api", Ports: []PortSpec{{Name: "http", Port: 80}, {Name: "http", Port: 8080}}},
+		"PortOutOfRange":            {Name: "api", Ports: []PortSpec{{Port: 70000}}},
+		"UnsupportedProtocol":       {Name: "api", Ports: []PortSpec{{Port: 80, Protocol: "ICMP"}}},
+		"NodePortOnClusterIP":       {Name: "api", Ports: []PortSpec{{Port: 80, NodePort: 30080}}},
+		"ExternalNameWithoutTarget": {Name: "api", Type: v1.ServiceTypeExternalName},
+		"HeadlessNodePort":          {Name: "api", Type: v1.ServiceTypeNodePort, Headless: true, Ports: []PortSpec{{Port: 80}}},
+		"UnknownType":               {Name: "api", Type: "Ingress", Ports: []PortSpec{{Port: 80}}},
+	}
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, spec.Validate())
+		})
+	}
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	k8sClient := NewK8sServiceClient(clientset, "default")
+
+	t.Run("CreateServiceFromSpec", func(t *testing.T) {
+		created, err := k8sClient.CreateServiceFromSpec(ServiceSpec{
+			Name:        "api",
+			Selector:    map[string]string{"app.kubernetes.io/name": "api"},
+			Labels:      map[string]string{"team": "platform"},
+			Annotations: map[string]string{"owner": "platform@example.com"},
+			Ports: []PortSpec{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, v1.ServiceTypeClusterIP, created.Spec.Type)
+		assert.Equal(t, map[string]string{"app.kubernetes.io/name": "api"}, created.Spec.Selector)
+		assert.Equal(t, "platform", created.Labels["team"])
+		assert.Equal(t, "platform@example.com", created.Annotations["owner"])
+		if assert.Len(t, created.Spec.Ports, 2) {
+			assert.Equal(t, intstr.FromString("http"), created.Spec.Ports[0].TargetPort)
+			assert.Equal(t, intstr.FromInt32(53), created.Spec.Ports[1].TargetPort)
+			assert.Equal(t, v1.ProtocolUDP, created.Spec.Ports[1].Protocol)
+		}
+
+		_, err = k8sClient.CreateServiceFromSpec(ServiceSpec{Name: "api", Ports: []PortSpec{{Port: 80}}})
+		assert.Error(t, err, "an existing service is not retried or overwritten")
+	})
+
+	t.Run("CreateService", func(t *testing.T) {
+		created, err := k8sClient.CreateService(ServiceData{
+			Name:  "auth",
+			Ports: []ServicePort{{Name: "http", Port: 80}, {Name: "dns", Port: 53, Protocol: "UDP"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "default", created.Namespace)
+		assert.Equal(t, v1.ServiceTypeClusterIP, created.Spec.Type)
+		assert.Equal(t, map[string]string{"app": "auth"}, created.Spec.Selector)
+		if assert.Len(t, created.Spec.Ports, 2) {
+			assert.Equal(t, v1.ProtocolTCP, created.Spec.Ports[0].Protocol)
+			assert.Equal(t, v1.ProtocolUDP, created.Spec.Ports[1].Protocol)
+		}
+	})
+
+	t.Run("DefaultSelector", func(t *testing.T) {
+		created, err := k8sClient.CreateServiceFromSpec(ServiceSpec{Name: "web", Ports: []PortSpec{{Port: 80}}})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"app": "web"}, created.Spec.Selector)
+	})
+
+	t.Run("Types", func(t *testing.T) {
+		headless, err := k8sClient.CreateServiceFromSpec(ServiceSpec{Name: "db", Headless: true, Ports: []PortSpec{{Port: 5432}}})
+		assert.NoError(t, err)
+		assert.Equal(t, v1.ClusterIPNone, headless.Spec.ClusterIP)
+
+		nodePort, err := k8sClient.CreateServiceFromSpec(ServiceSpec{
+			Name:  "ingress",
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []PortSpec{{Port: 80, NodePort: 30080}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(30080), nodePort.Spec.Ports[0].NodePort)
+
+		lb, err := k8sClient.CreateServiceFromSpec(ServiceSpec{Name: "edge", Type: v1.ServiceTypeLoadBalancer, Ports: []PortSpec{{Port: 443}}})
+		assert.NoError(t, err)
+		assert.Equal(t, v1.ServiceTypeLoadBalancer, lb.Spec.Type)
+
+		external, err := k8sClient.CreateServiceFromSpec(ServiceSpec{Name: "billing", Type: v1.ServiceTypeExternalName, ExternalName: "billing.example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "billing.example.com", external.Spec.ExternalName)
+		assert.Empty(t, external.Spec.Selector)
+	})
+
+	t.Run("UpdateService", func(t *testing.T) {
+		updated, err := k8sClient.UpdateService(ServiceSpec{
+			Name:  "ingress",
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []PortSpec{{Port: 80}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(30080), updated.Spec.Ports[0].NodePort, "allocated node ports are kept")
+
+		updated, err = k8sClient.UpdateService(ServiceSpec{
+			Name:   "web",
+			Labels: map[string]string{"tier": "frontend"},
+			Ports:  []PortSpec{{Name: "http", Port: 80}, {Name: "https", Port: 443, TargetPort: intstr.FromInt32(8443)}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "frontend", updated.Labels["tier"])
+		assert.Len(t, updated.Spec.Ports, 2)
+
+		current, err := clientset.CoreV1().Services("default").Get(ctx, "web", metav1.GetOptions{})
+		assert.NoError(t, err)
+		current.Labels["owner"] = "helm"
+		_, err = clientset.CoreV1().Services("default").Update(ctx, current, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+		updated, err = k8sClient.UpdateService(ServiceSpec{
+			Name:   "web",
+			Labels: map[string]string{"tier": "backend"},
+			Ports:  []PortSpec{{Port: 80}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"tier": "backend", "owner": "helm"}, updated.Labels, "labels outside the spec are kept")
+
+		_, err = k8sClient.UpdateService(ServiceSpec{Name: "db", Ports: []PortSpec{{Port: 5432}}})
+		assert.Error(t, err, "headless services stay headless")
+
+		_, err = k8sClient.UpdateService(ServiceSpec{Name: "missing", Ports: []PortSpec{{Port: 80}}})
+		assert.Error(t, err)
+	})
+
+	t.Run("UpdateRetriesOnConflict", func(t *testing.T) {
+		conflicts := 1
+		clientset.PrependReactor("update", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+			conflicts--
+			return true, nil, apierrors.NewConflict(v1.Resource("services"), "web", errors.New("the object has been modified"))
+		})
+
+		updated, err := k8sClient.UpdateService(ServiceSpec{Name: "web", Labels: map[string]string{"tier": "edge"}, Ports: []PortSpec{{Port: 80}}})
+		assert.NoError(t, err)
+		assert.Equal(t, "edge", updated.Labels["tier"])
+		assert.Equal(t, 0, conflicts)
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		applied, err := k8sClient.Apply(ServiceSpec{
+			Name:   "metrics",
+			Labels: map[string]string{"team": "observability"},
+			Ports:  []PortSpec{{Name: "metrics", Port: 9090}},
+		}, ApplyOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "observability", applied.Labels["team"])
+
+		applied, err = k8sClient.Apply(ServiceSpec{
+			Name:   "metrics",
+			Labels: map[string]string{"team": "observability"},
+			Ports:  []PortSpec{{Name: "metrics", Port: 9091}},
+		}, ApplyOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, applied.Spec.Ports, 1) {
+			assert.Equal(t, int32(9091), applied.Spec.Ports[0].Port)
+		}
+	})
+
+	t.Run("ApplyConflict", func(t *testing.T) {
+		_, err := clientset.CoreV1().Services("default").Apply(ctx,
+			corev1ac.Service("metrics", "default").WithLabels(map[string]string{"team": "sre"}),
+			metav1.ApplyOptions{FieldManager: "kubectl", Force: true})
+		assert.NoError(t, err)
+
+		spec := ServiceSpec{
+			Name:   "metrics",
+			Labels: map[string]string{"team": "observability"},
+			Ports:  []PortSpec{{Name: "metrics", Port: 9091}},
+		}
+		_, err = k8sClient.Apply(spec, ApplyOptions{})
+		assert.True(t, apierrors.IsConflict(err), "fields owned by another manager are not taken over: %v", err)
+
+		applied, err := k8sClient.Apply(spec, ApplyOptions{Force: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "observability", applied.Labels["team"])
+	})
+
+	t.Run("DeleteService", func(t *testing.T) {
+		assert.NoError(t, k8sClient.DeleteService("", "web"))
+		_, err := clientset.CoreV1().Services("default").Get(ctx, "web", metav1.GetOptions{})
+		assert.Error(t, err)
+
+		assert.NoError(t, k8sClient.DeleteService("default", "web"), "deleting twice is not an error")
+	})
+
+	t.Run("AllNamespacesNeedsNamespace", func(t *testing.T) {
+		client := NewK8sServiceClient(clientset, metav1.NamespaceAll)
+		_, err := client.CreateServiceFromSpec(ServiceSpec{Name: "api", Ports: []PortSpec{{Port: 80}}})
+		assert.Error(t, err)
+
+		created, err := client.CreateServiceFromSpec(ServiceSpec{Name: "api", Namespace: "staging", Ports: []PortSpec{{Port: 80}}})
+		assert.NoError(t, err)
+		assert.Equal(t, "staging", created.Namespace)
+	})
+}
@@ -1,3 +1,402 @@
 package service
 
-//TODO: need implementation in the future
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Workload kinds a pod can be attributed to.
+const (
+	KindDeployment  = "Deployment"
+	KindReplicaSet  = "ReplicaSet"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindJob         = "Job"
+	KindCronJob     = "CronJob"
+)
+
+// Workload is the top-level controller that owns a pod, such as the
+// Deployment behind its ReplicaSet.
+type Workload struct {
+	Kind string `bson:"kind" json:"kind"`
+	Name string `bson:"name" json:"name"`
+}
+
+// PodData represents a running pod, the services selecting it and the
+// workload that owns it
+type PodData struct {
+	Name      string    `bson:"name" json:"name"`
+	Namespace string    `bson:"namespace" json:"namespace"`
+	IPs       []string  `bson:"ip_addresses" json:"ip_addresses"`
+	Node      string    `bson:"node,omitempty" json:"node,omitempty"`
+	Services  []string  `bson:"services,omitempty" json:"services,omitempty"`
+	Workload  *Workload `bson:"workload,omitempty" json:"workload,omitempty"`
+}
+
+// HasIP reports whether ip is one of the pod's addresses
+func (p PodData) HasIP(ip string) bool {
+	for _, candidate := range p.IPs {
+		if candidate == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// PodClient is a wrapper around Kubernetes client for looking up pods
+type PodClient struct {
+	clientset kubernetes.Interface
+	namespace string
+	resolver  *PodResolver
+}
+
+// NewPodClient creates a new instance of PodClient
+func NewPodClient(clientset kubernetes.Interface, namespace string) *PodClient {
+	return &PodClient{
+		clientset: clientset,
+		namespace: namespace,
+	}
+}
+
+// WithResolver makes GetPodByIP answer from the resolver's indexes once it
+// has synced, instead of asking the API server.
+func (p *PodClient) WithResolver(resolver *PodResolver) *PodClient {
+	p.resolver = resolver
+	return p
+}
+
+// GetAllPods retrieves all pods in the given namespace that have an IP
+// address of their own, with the services selecting them and their owning
+// workloads. Host-network pods and pods that have finished are skipped.
+func (p *PodClient) GetAllPods() ([]PodData, error) {
+	return p.listPods(context.Background(), metav1.ListOptions{})
+}
+
+// GetPodByIP returns the pod with the given IP address. With a synced
+// resolver it is read from the resolver's IP index; otherwise only the pods
+// holding that address are requested from the API server.
+func (p *PodClient) GetPodByIP(ip string) (*PodData, error) {
+	if p.resolver != nil && p.resolver.HasSynced() {
+		if pod, ok := p.resolver.LookupPod(ip); ok {
+			return pod, nil
+		}
+		return nil, fmt.Errorf("no pod with IP %s", ip)
+	}
+
+	pods, err := p.listPods(context.Background(), metav1.ListOptions{FieldSelector: "status.podIP=" + ip})
+	if err != nil {
+		return nil, err
+	}
+	// The field selector is not honoured by every client, so check the address too.
+	for i := range pods {
+		if pods[i].HasIP(ip) {
+			return &pods[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no pod with IP %s", ip)
+}
+
+// listPods lists the attributable pods matching opts with their services
+// and workloads, as GetAllPods describes.
+func (p *PodClient) listPods(ctx context.Context, opts metav1.ListOptions) ([]PodData, error) {
+	podList, err := p.clientset.CoreV1().Pods(p.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	if len(podList.Items) == 0 {
+		return nil, nil
+	}
+
+	sliceList, err := p.clientset.DiscoveryV1().EndpointSlices(p.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoint slices: %v", err)
+	}
+	services := podServices(sliceList.Items)
+
+	owners := newOwnerResolver(p.clientset)
+	var pods []PodData
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !attributable(pod) {
+			continue
+		}
+		data := podDataFrom(pod)
+		if len(data.IPs) == 0 {
+			continue
+		}
+		data.Services = services.lookup(pod)
+		data.Workload = owners.workload(ctx, pod)
+		pods = append(pods, data)
+	}
+
+	return pods, nil
+}
+
+// attributable reports whether traffic from the pod's addresses can be
+// attributed to it. Host-network pods share their node's address, and pods
+// that have succeeded or failed may have handed theirs to a new pod.
+func attributable(pod *v1.Pod) bool {
+	if pod.Spec.HostNetwork {
+		return false
+	}
+	return pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+// podDataFrom collects the name, node and addresses of a pod
+func podDataFrom(pod *v1.Pod) PodData {
+	data := PodData{Name: pod.Name, Namespace: pod.Namespace, Node: pod.Spec.NodeName}
+	for _, podIP := range pod.Status.PodIPs {
+		if podIP.IP != "" && !data.HasIP(podIP.IP) {
+			data.IPs = append(data.IPs, podIP.IP)
+		}
+	}
+	if pod.Status.PodIP != "" && !data.HasIP(pod.Status.PodIP) {
+		data.IPs = append(data.IPs, pod.Status.PodIP)
+	}
+	return data
+}
+
+// podServiceIndex maps pods to the services whose endpoint slices list them,
+// by pod reference and, for endpoints without one, by address.
+type podServiceIndex struct {
+	byPod     map[string][]string
+	byAddress map[string][]string
+}
+
+func podServices(slices []discoveryv1.EndpointSlice) podServiceIndex {
+	index := podServiceIndex{byPod: make(map[string][]string), byAddress: make(map[string][]string)}
+	for _, slice := range slices {
+		name, ok := slice.Labels[discoveryv1.LabelServiceName]
+		if !ok {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if ref := endpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+				key := slice.Namespace + "/" + ref.Name
+				index.byPod[key] = appendUnique(index.byPod[key], name)
+				continue
+			}
+			for _, ip := range endpoint.Addresses {
+				key := slice.Namespace + "/" + ip
+				index.byAddress[key] = appendUnique(index.byAddress[key], name)
+			}
+		}
+	}
+	return index
+}
+
+func (i podServiceIndex) lookup(pod *v1.Pod) []string {
+	services := append([]string(nil), i.byPod[pod.Namespace+"/"+pod.Name]...)
+	for _, ip := range podDataFrom(pod).IPs {
+		for _, name := range i.byAddress[pod.Namespace+"/"+ip] {
+			services = appendUnique(services, name)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// ownerResolver follows controller owner references from a pod up to its
+// workload, fetching the ReplicaSets and Jobs in between.
+type ownerResolver struct {
+	replicaSet func(ctx context.Context, namespace, name string) (*appsv1.ReplicaSet, error)
+	job        func(ctx context.Context, namespace, name string) (*batchv1.Job, error)
+}
+
+// newOwnerResolver creates an ownerResolver fetching from the API server,
+// remembering the ReplicaSets and Jobs it has already fetched.
+func newOwnerResolver(clientset kubernetes.Interface) *ownerResolver {
+	replicaSets := make(map[string]*appsv1.ReplicaSet)
+	jobs := make(map[string]*batchv1.Job)
+	return &ownerResolver{
+		replicaSet: func(ctx context.Context, namespace, name string) (*appsv1.ReplicaSet, error) {
+			key := namespace + "/" + name
+			if rs, ok := replicaSets[key]; ok {
+				return rs, nil
+			}
+			rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			replicaSets[key] = rs
+			return rs, nil
+		},
+		job: func(ctx context.Context, namespace, name string) (*batchv1.Job, error) {
+			key := namespace + "/" + name
+			if job, ok := jobs[key]; ok {
+				return job, nil
+			}
+			job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			jobs[key] = job
+			return job, nil
+		},
+	}
+}
+
+// newListerOwnerResolver creates an ownerResolver reading from informer caches.
+func newListerOwnerResolver(replicaSets appslisters.ReplicaSetLister, jobs batchlisters.JobLister) *ownerResolver {
+	return &ownerResolver{
+		replicaSet: func(_ context.Context, namespace, name string) (*appsv1.ReplicaSet, error) {
+			return replicaSets.ReplicaSets(namespace).Get(name)
+		},
+		job: func(_ context.Context, namespace, name string) (*batchv1.Job, error) {
+			return jobs.Jobs(namespace).Get(name)
+		},
+	}
+}
+
+// workload returns the pod's top-level controller, or nil for a bare pod.
+// A ReplicaSet or Job that cannot be fetched is reported as the workload
+// itself.
+func (o *ownerResolver) workload(ctx context.Context, pod *v1.Pod) *Workload {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil
+	}
+
+	switch owner.Kind {
+	case KindReplicaSet:
+		rs, err := o.replicaSet(ctx, pod.Namespace, owner.Name)
+		if err != nil {
+			log.Debug().Err(err).Str("replicaset", owner.Name).Msg("failed to resolve pod owner")
+			break
+		}
+		if parent := metav1.GetControllerOf(rs); parent != nil && parent.Kind == KindDeployment {
+			return &Workload{Kind: KindDeployment, Name: parent.Name}
+		}
+	case KindJob:
+		job, err := o.job(ctx, pod.Namespace, owner.Name)
+		if err != nil {
+			log.Debug().Err(err).Str("job", owner.Name).Msg("failed to resolve pod owner")
+			break
+		}
+		if parent := metav1.GetControllerOf(job); parent != nil && parent.Kind == KindCronJob {
+			return &Workload{Kind: KindCronJob, Name: parent.Name}
+		}
+	}
+	return &Workload{Kind: owner.Kind, Name: owner.Name}
+}
+
+// ErrPodsNotSynced is reported until the pod resolver has completed its initial list.
+var ErrPodsNotSynced = errors.New("pod resolver has not synced")
+
+// PodResolver is a watch-driven IP→pod index, so traffic can be attributed
+// to workloads without listing pods on every request. Pods, EndpointSlices
+// and the ReplicaSets and Jobs owning pods are read from shared informers.
+type PodResolver struct {
+	pods   cache.SharedIndexInformer
+	slices cache.SharedIndexInformer
+	synced []cache.InformerSynced
+	owners *ownerResolver
+}
+
+// NewPodResolver creates a resolver for the pods factory's informers cover.
+// It fills once the factory is started, and only if the caller may watch
+// pods, EndpointSlices, ReplicaSets and Jobs.
+func NewPodResolver(factory informers.SharedInformerFactory) (*PodResolver, error) {
+	pods := factory.Core().V1().Pods()
+	slices := factory.Discovery().V1().EndpointSlices()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	jobs := factory.Batch().V1().Jobs()
+
+	if err := addIndexer(pods.Informer(), ipIndex, podIPs); err != nil {
+		return nil, fmt.Errorf("failed to index pods: %v", err)
+	}
+	if err := addIndexer(slices.Informer(), ipIndex, endpointSliceIPs); err != nil {
+		return nil, fmt.Errorf("failed to index endpoint slices: %v", err)
+	}
+
+	return &PodResolver{
+		pods:   pods.Informer(),
+		slices: slices.Informer(),
+		synced: []cache.InformerSynced{
+			pods.Informer().HasSynced,
+			slices.Informer().HasSynced,
+			replicaSets.Informer().HasSynced,
+			jobs.Informer().HasSynced,
+		},
+		owners: newListerOwnerResolver(replicaSets.Lister(), jobs.Lister()),
+	}, nil
+}
+
+// WaitForSync blocks until the initial list has been loaded or ctx is done,
+// and reports whether the resolver synced.
+func (r *PodResolver) WaitForSync(ctx context.Context) bool {
+	return cache.WaitForCacheSync(ctx.Done(), r.synced...)
+}
+
+// HasSynced reports whether the initial lists have been loaded.
+func (r *PodResolver) HasSynced() bool {
+	for _, synced := range r.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Ready returns ErrPodsNotSynced until the resolver has synced, for use in readiness checks.
+func (r *PodResolver) Ready() error {
+	if !r.HasSynced() {
+		return ErrPodsNotSynced
+	}
+	return nil
+}
+
+// LookupPod returns the pod with the given IP address, with the services
+// selecting it and its owning workload.
+func (r *PodResolver) LookupPod(ip string) (*PodData, bool) {
+	objs, err := r.pods.GetIndexer().ByIndex(ipIndex, ip)
+	if err != nil || len(objs) == 0 {
+		return nil, false
+	}
+	pod := objs[0].(*v1.Pod)
+
+	var slices []discoveryv1.EndpointSlice
+	data := podDataFrom(pod)
+	for _, podIP := range data.IPs {
+		objs, _ := r.slices.GetIndexer().ByIndex(ipIndex, podIP)
+		for _, obj := range objs {
+			slices = append(slices, *obj.(*discoveryv1.EndpointSlice))
+		}
+	}
+	data.Services = podServices(slices).lookup(pod)
+	data.Workload = r.owners.workload(context.Background(), pod)
+	return &data, true
+}
+
+// podIPs is the index function listing the addresses of a pod that traffic
+// can be attributed to.
+func podIPs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || !attributable(pod) {
+		return nil, nil
+	}
+	return podDataFrom(pod).IPs, nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func newPod(name, ip string, owners []metav1.OwnerReference) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{PodIP: ip, PodIPs: []v1.PodIP{{IP: ip}}},
+	}
+}
+
+func TestPodClient(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "login-7d9f", Namespace: "default", OwnerReferences: controlledBy(KindDeployment, "login"),
+		}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name: "report-28861", Namespace: "default", OwnerReferences: controlledBy(KindCronJob, "report"),
+		}},
+		newPod("login-7d9f-x2x", "10.128.72.69", controlledBy(KindReplicaSet, "login-7d9f")),
+		newPod("db-0", "10.128.72.70", controlledBy(KindStatefulSet, "db")),
+		newPod("agent-abc", "10.128.72.71", controlledBy(KindDaemonSet, "agent")),
+		newPod("report-28861-q", "10.128.72.72", controlledBy(KindJob, "report-28861")),
+		newPod("orphan-rs-pod", "10.128.72.73", controlledBy(KindReplicaSet, "gone")),
+		newPod("debug", "10.128.72.74", nil),
+		newPod("pending", "", nil),
+		func() *v1.Pod {
+			pod := newPod("node-exporter-abc", "10.128.0.5", controlledBy(KindDaemonSet, "node-exporter"))
+			pod.Spec.HostNetwork = true
+			return pod
+		}(),
+		func() *v1.Pod {
+			pod := newPod("report-28860-q", "10.128.72.75", controlledBy(KindJob, "report-28860"))
+			pod.Status.Phase = v1.PodSucceeded
+			return pod
+		}(),
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "login-abc",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "login"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.128.72.69"}, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "login-7d9f-x2x"}},
+				{Addresses: []string{"10.128.72.74"}},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db-abc",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "db"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.128.72.70"}, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "db-0"}},
+			},
+		},
+	)
+	client := NewPodClient(clientset, "default")
+
+	t.Run("GetAllPods", func(t *testing.T) {
+		pods, err := client.GetAllPods()
+		assert.NoError(t, err)
+		assert.Len(t, pods, 6, "pods without an IP of their own or that have finished are skipped")
+	})
+
+	t.Run("Workloads", func(t *testing.T) {
+		tests := map[string]*Workload{
+			"10.128.72.69": {Kind: KindDeployment, Name: "login"},
+			"10.128.72.70": {Kind: KindStatefulSet, Name: "db"},
+			"10.128.72.71": {Kind: KindDaemonSet, Name: "agent"},
+			"10.128.72.72": {Kind: KindCronJob, Name: "report"},
+			"10.128.72.73": {Kind: KindReplicaSet, Name: "gone"},
+			"10.128.72.74": nil,
+		}
+		for ip, want := range tests {
+			pod, err := client.GetPodByIP(ip)
+			if assert.NoError(t, err, ip) {
+				assert.Equal(t, want, pod.Workload, ip)
+			}
+		}
+	})
+
+	t.Run("Services", func(t *testing.T) {
+		pod, err := client.GetPodByIP("10.128.72.69")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"login"}, pod.Services)
+		assert.Equal(t, "node-1", pod.Node)
+
+		pod, err = client.GetPodByIP("10.128.72.74")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"login"}, pod.Services, "matched by address without a target ref")
+
+		pod, err = client.GetPodByIP("10.128.72.71")
+		assert.NoError(t, err)
+		assert.Empty(t, pod.Services)
+	})
+
+	t.Run("UnknownIP", func(t *testing.T) {
+		_, err := client.GetPodByIP("10.0.0.1")
+		assert.Error(t, err)
+	})
+
+	t.Run("PodResolver", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace("default"))
+		_, err := NewServiceCache(factory, "default", Selectors{})
+		assert.NoError(t, err)
+		resolver, err := NewPodResolver(factory)
+		assert.NoError(t, err, "the endpoint slice informer is shared with the service cache")
+		assert.ErrorIs(t, resolver.Ready(), ErrPodsNotSynced)
+		factory.Start(ctx.Done())
+		assert.True(t, resolver.WaitForSync(ctx))
+		assert.NoError(t, resolver.Ready())
+
+		indexed := NewPodClient(clientset, "default").WithResolver(resolver)
+		pod, err := indexed.GetPodByIP("10.128.72.71")
+		if assert.NoError(t, err) {
+			assert.Equal(t, &Workload{Kind: KindDaemonSet, Name: "agent"}, pod.Workload)
+		}
+		_, err = indexed.GetPodByIP("10.0.0.1")
+		assert.Error(t, err)
+
+		pod, ok := resolver.LookupPod("10.128.72.69")
+		if assert.True(t, ok) {
+			assert.Equal(t, "login-7d9f-x2x", pod.Name)
+			assert.Equal(t, []string{"login"}, pod.Services)
+			assert.Equal(t, &Workload{Kind: KindDeployment, Name: "login"}, pod.Workload)
+		}
+		pod, ok = resolver.LookupPod("10.128.72.72")
+		if assert.True(t, ok) {
+			assert.Equal(t, &Workload{Kind: KindCronJob, Name: "report"}, pod.Workload)
+		}
+
+		_, ok = resolver.LookupPod("10.128.0.5")
+		assert.False(t, ok, "host-network pods are not indexed")
+		_, ok = resolver.LookupPod("10.128.72.75")
+		assert.False(t, ok, "finished pods are not indexed")
+
+		assert.NoError(t, clientset.CoreV1().Pods("default").Delete(ctx, "db-0", metav1.DeleteOptions{}))
+		assert.Eventually(t, func() bool {
+			_, ok := resolver.LookupPod("10.128.72.70")
+			return !ok
+		}, time.Second, 5*time.Millisecond)
+	})
+}
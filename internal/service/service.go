@@ -3,27 +3,168 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/util/retry"
 )
 
+// SourceKubernetes marks inventory records that were synced from the cluster.
+const SourceKubernetes = "kubernetes"
+
 // ServiceData represents the data for a Kubernetes service
 type ServiceData struct {
-	Name string
-	IP   string
-	Port int32
+	Name string `bson:"name" json:"name"`
+	// Namespace is the Kubernetes namespace of the service; it is empty for
+	// records loaded by hand without one.
+	Namespace string        `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	IPs       []string      `bson:"ip_addresses" json:"ip_addresses"`
+	Ports     []ServicePort `bson:"ports" json:"ports"`
+	// Endpoints are the pod addresses behind the service with the ports
+	// they listen on, which differ from Ports when a targetPort is set.
+	Endpoints []ServiceEndpoint `bson:"endpoints,omitempty" json:"endpoints,omitempty"`
+	// Labels are the service's Kubernetes labels, kept so the records a
+	// label selector covered can be found after the service is gone.
+	Labels map[string]string `bson:"labels,omitempty" json:"labels,omitempty"`
+	// Source says where the record came from; it is empty for records loaded by hand.
+	Source string `bson:"source,omitempty" json:"source,omitempty"`
+	// SyncedAt is when the record was last refreshed from its source.
+	SyncedAt *time.Time `bson:"synced_at,omitempty" json:"synced_at,omitempty"`
+	// RemovedAt is set once the service is no longer found in its source.
+	RemovedAt *time.Time `bson:"removed_at,omitempty" json:"removed_at,omitempty"`
+}
+
+// ServiceID identifies a service by namespace and name
+type ServiceID struct {
+	Namespace string
+	Name      string
+}
+
+// String returns the ID as namespace/name, or just the name without a namespace
+func (id ServiceID) String() string {
+	if id.Namespace == "" {
+		return id.Name
+	}
+	return id.Namespace + "/" + id.Name
+}
+
+// ID returns the namespace and name identifying the service
+func (s ServiceData) ID() ServiceID {
+	return ServiceID{Namespace: s.Namespace, Name: s.Name}
+}
+
+// ServicePort is a single port exposed by a service
+type ServicePort struct {
+	Name     string `bson:"name,omitempty" json:"name,omitempty"`
+	Port     int32  `bson:"port" json:"port"`
+	Protocol string `bson:"protocol,omitempty" json:"protocol,omitempty"`
+}
+
+// ServiceEndpoint is a pod address backing a service. Its ports carry the
+// service port name with the port number the pod listens on.
+type ServiceEndpoint struct {
+	IP    string        `bson:"ip" json:"ip"`
+	Ports []ServicePort `bson:"ports,omitempty" json:"ports,omitempty"`
+}
+
+// HasIP reports whether ip is one of the service's addresses
+func (s ServiceData) HasIP(ip string) bool {
+	for _, candidate := range s.IPs {
+		if candidate == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPort returns the service port matching the given number, if any
+func (s ServiceData) FindPort(port int32) *ServicePort {
+	for i := range s.Ports {
+		if s.Ports[i].Port == port {
+			found := s.Ports[i]
+			return &found
+		}
+	}
+	return nil
+}
+
+// PortFor returns the port matching a flow to or from ip. Traffic to an
+// endpoint address is matched on the ports that endpoint listens on, and
+// traffic to any other address, such as a cluster IP, on the service ports.
+func (s ServiceData) PortFor(ip string, port int32) *ServicePort {
+	for _, endpoint := range s.Endpoints {
+		if endpoint.IP != ip || len(endpoint.Ports) == 0 {
+			continue
+		}
+		for i := range endpoint.Ports {
+			if endpoint.Ports[i].Port == port {
+				found := endpoint.Ports[i]
+				return &found
+			}
+		}
+		return nil
+	}
+	return s.FindPort(port)
+}
+
+// Selectors restrict which services are listed, in the label and field
+// selector syntax of the Kubernetes API. Empty selectors match everything.
+type Selectors struct {
+	Label string
+	Field string
+}
+
+// listOptions applies the selectors to a list request
+func (s Selectors) listOptions(opts *metav1.ListOptions) {
+	opts.LabelSelector = s.Label
+	opts.FieldSelector = s.Field
+}
+
+// Scope is the part of the cluster a service listing covers: a namespace,
+// empty for every namespace, narrowed by selectors.
+type Scope struct {
+	Namespace string
+	Selectors Selectors
+}
+
+// Contains reports whether a stored service record falls inside the scope.
+// Label selectors are matched against the record's labels and field
+// selectors against metadata.name and metadata.namespace. A selector that
+// does not parse, or that needs any other field, never matches, so a record
+// is only reported when it is known to be in scope.
+func (s Scope) Contains(svc ServiceData) bool {
+	if s.Namespace != metav1.NamespaceAll && svc.Namespace != s.Namespace {
+		return false
+	}
+	label, err := labels.Parse(s.Selectors.Label)
+	if err != nil || !label.Matches(labels.Set(svc.Labels)) {
+		return false
+	}
+	field, err := fields.ParseSelector(s.Selectors.Field)
+	if err != nil {
+		return false
+	}
+	for _, requirement := range field.Requirements() {
+		if requirement.Field != "metadata.name" && requirement.Field != "metadata.namespace" {
+			return false
+		}
+	}
+	return field.Matches(fields.Set{"metadata.name": svc.Name, "metadata.namespace": svc.Namespace})
 }
 
 // K8sServiceClient is a wrapper around Kubernetes client for interacting with services
 type K8sServiceClient struct {
 	clientset kubernetes.Interface
 	namespace string
+	selectors Selectors
 }
 
-// NewK8sServiceClient creates a new instance of K8sServiceClient
+// NewK8sServiceClient creates a new instance of K8sServiceClient. An empty
+// namespace (metav1.NamespaceAll) works across every namespace.
 func NewK8sServiceClient(clientset kubernetes.Interface, namespace string) *K8sServiceClient {
 	return &K8sServiceClient{
 		clientset: clientset,
@@ -31,81 +172,152 @@ func NewK8sServiceClient(clientset kubernetes.Interface, namespace string) *K8sS
 	}
 }
 
-// CreateService creates a service in the specified namespace
-func (k *K8sServiceClient) CreateService(serviceData ServiceData) (*v1.Service, error) {
-	service := &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: serviceData.Name,
-		},
-		Spec: v1.ServiceSpec{
-			Selector: map[string]string{"app": serviceData.Name},
-			Ports: []v1.ServicePort{
-				{
-					Port: serviceData.Port,
-				},
-			},
-			Type: v1.ServiceTypeClusterIP,
-		},
-	}
-
-	// Here, you could integrate logic to push information to MongoDB if needed
-	// mongoClient, _ := database.CreateMongoClient()
-	// err := mongoClient.InsertAPIData("testdb", "testcollection", service)
-	// if err != nil {
-	//     return nil, err
-	// }
-
-	// Retry logic in case of transient failures
-	var createdService *v1.Service
-	err := retry.OnError(retry.DefaultRetry, isRetryableError, func() error {
-		var err error
-		createdService, err = k.clientset.CoreV1().Services(k.namespace).Create(context.Background(), service, metav1.CreateOptions{})
-		return err
-	})
+// WithSelectors restricts GetAllServices to the services matching selectors
+func (k *K8sServiceClient) WithSelectors(selectors Selectors) *K8sServiceClient {
+	k.selectors = selectors
+	return k
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create service after retries: %v", err)
-	}
+// Scope returns the namespace and selectors GetAllServices lists from
+func (k *K8sServiceClient) Scope() Scope {
+	return Scope{Namespace: k.namespace, Selectors: k.selectors}
+}
 
-	return createdService, nil
+// AllNamespaces reports whether the client works across every namespace
+func (k *K8sServiceClient) AllNamespaces() bool {
+	return k.namespace == metav1.NamespaceAll
 }
 
-// GetService fetches a service by its name in the given namespace
+// GetService fetches a service by its name in the client's namespace. In
+// all-namespaces mode the name must be unique across the cluster; use
+// GetNamespacedService otherwise.
 func (k *K8sServiceClient) GetService(serviceName string) (*ServiceData, error) {
-	service, err := k.clientset.CoreV1().Services(k.namespace).Get(context.Background(), serviceName, metav1.GetOptions{})
+	if !k.AllNamespaces() {
+		return k.GetNamespacedService(k.namespace, serviceName)
+	}
+
+	serviceList, err := k.clientset.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "metadata.name=" + serviceName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	var namespaces []string
+	for _, svc := range serviceList.Items {
+		// The field selector is not honoured by every client, so check the name too.
+		if svc.Name == serviceName {
+			namespaces = append(namespaces, svc.Namespace)
+		}
+	}
+	switch len(namespaces) {
+	case 0:
+		return nil, fmt.Errorf("failed to get service: service %s not found in any namespace", serviceName)
+	case 1:
+		return k.GetNamespacedService(namespaces[0], serviceName)
+	default:
+		return nil, fmt.Errorf("service %s exists in several namespaces %v, a namespace is required", serviceName, namespaces)
+	}
+}
+
+// GetNamespacedService fetches a service by its namespace and name
+func (k *K8sServiceClient) GetNamespacedService(namespace, serviceName string) (*ServiceData, error) {
+	service, err := k.clientset.CoreV1().Services(namespace).Get(context.Background(), serviceName, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get service: %v", err)
 	}
 
-	return &ServiceData{
-		Name: service.Name,
-		IP:   service.Spec.ClusterIP,
-		Port: service.Spec.Ports[0].Port,
-	}, nil
+	slices, err := k.clientset.DiscoveryV1().EndpointSlices(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + serviceName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoint slices: %v", err)
+	}
+
+	data := serviceDataFrom(service, slices.Items)
+	return &data, nil
 }
 
-// GetAllServices retrieves all services in the given namespace
+// GetAllServices retrieves all services matching the client's selectors in
+// its namespace, or in every namespace in all-namespaces mode
 func (k *K8sServiceClient) GetAllServices() ([]ServiceData, error) {
-	serviceList, err := k.clientset.CoreV1().Services(k.namespace).List(context.Background(), metav1.ListOptions{})
+	var opts metav1.ListOptions
+	k.selectors.listOptions(&opts)
+	serviceList, err := k.clientset.CoreV1().Services(k.namespace).List(context.Background(), opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list services: %v", err)
 	}
 
+	sliceList, err := k.clientset.DiscoveryV1().EndpointSlices(k.namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoint slices: %v", err)
+	}
+	slicesByService := make(map[ServiceID][]discoveryv1.EndpointSlice)
+	for _, slice := range sliceList.Items {
+		id := ServiceID{Namespace: slice.Namespace, Name: slice.Labels[discoveryv1.LabelServiceName]}
+		slicesByService[id] = append(slicesByService[id], slice)
+	}
+
 	var services []ServiceData
-	for _, svc := range serviceList.Items {
-		services = append(services, ServiceData{
-			Name: svc.Name,
-			IP:   svc.Spec.ClusterIP,
-			Port: svc.Spec.Ports[0].Port, // Extracting the Port number
-		})
+	for i := range serviceList.Items {
+		svc := &serviceList.Items[i]
+		services = append(services, serviceDataFrom(svc, slicesByService[ServiceID{Namespace: svc.Namespace, Name: svc.Name}]))
 	}
 
 	return services, nil
 }
 
-// isRetryableError checks if an error is retryable
-func isRetryableError(err error) bool {
-	// Customize this function based on the error types you want to retry
-	// For example, retry on network errors or temporary service failures
-	return err != nil
+// serviceDataFrom collects every cluster IP, endpoint address and port of a service
+func serviceDataFrom(svc *v1.Service, slices []discoveryv1.EndpointSlice) ServiceData {
+	data := ServiceData{Name: svc.Name, Namespace: svc.Namespace, Labels: svc.Labels}
+
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && svc.Spec.ClusterIP != "" {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+	for _, ip := range clusterIPs {
+		if ip != v1.ClusterIPNone && !data.HasIP(ip) {
+			data.IPs = append(data.IPs, ip)
+		}
+	}
+	for _, slice := range slices {
+		ports := endpointPorts(slice.Ports)
+		for _, endpoint := range slice.Endpoints {
+			for _, ip := range endpoint.Addresses {
+				if !data.HasIP(ip) {
+					data.IPs = append(data.IPs, ip)
+					data.Endpoints = append(data.Endpoints, ServiceEndpoint{IP: ip, Ports: ports})
+				}
+			}
+		}
+	}
+
+	for _, port := range svc.Spec.Ports {
+		data.Ports = append(data.Ports, ServicePort{
+			Name:     port.Name,
+			Port:     port.Port,
+			Protocol: string(port.Protocol),
+		})
+	}
+
+	return data
+}
+
+// endpointPorts converts the ports of an endpoint slice. A port without a
+// number stands for every port and is left out.
+func endpointPorts(slicePorts []discoveryv1.EndpointPort) []ServicePort {
+	var ports []ServicePort
+	for _, port := range slicePorts {
+		if port.Port == nil {
+			continue
+		}
+		converted := ServicePort{Port: *port.Port}
+		if port.Name != nil {
+			converted.Name = *port.Name
+		}
+		if port.Protocol != nil {
+			converted.Protocol = string(*port.Protocol)
+		}
+		ports = append(ports, converted)
+	}
+	return ports
 }
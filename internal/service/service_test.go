@@ -2,6 +2,12 @@ package service
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestService(t *testing.T) {
@@ -79,3 +85,125 @@ func TestService(t *testing.T) {
 	// })
 
 }
+
+func TestGetServiceWithMultipleIPsAndPorts(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				ClusterIP:  "10.96.0.10",
+				ClusterIPs: []string{"10.96.0.10", "fd00::10"},
+				Ports: []v1.ServicePort{
+					{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP},
+					{Name: "metrics", Port: 9090, Protocol: v1.ProtocolTCP},
+				},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "api-abc12",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "api"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.244.0.5"}},
+				{Addresses: []string{"10.244.1.9"}},
+			},
+		},
+	)
+	k8sClient := NewK8sServiceClient(clientset, "default")
+
+	t.Run("GetService", func(t *testing.T) {
+		svc, err := k8sClient.GetService("api")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.96.0.10", "fd00::10", "10.244.0.5", "10.244.1.9"}, svc.IPs)
+		assert.Len(t, svc.Ports, 2)
+		assert.Equal(t, "metrics", svc.FindPort(9090).Name)
+		assert.Nil(t, svc.FindPort(443))
+	})
+
+	t.Run("GetAllServices", func(t *testing.T) {
+		services, err := k8sClient.GetAllServices()
+		assert.NoError(t, err)
+		assert.Len(t, services, 1)
+		assert.True(t, services[0].HasIP("10.244.1.9"))
+	})
+}
+
+func TestAllNamespaces(t *testing.T) {
+	newService := func(namespace, name, clusterIP string, labels map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Spec:       v1.ServiceSpec{ClusterIP: clusterIP},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		newService("prod", "auth", "10.96.1.10", map[string]string{"tier": "backend"}),
+		newService("staging", "auth", "10.96.2.10", map[string]string{"tier": "backend"}),
+		newService("prod", "web", "10.96.1.20", map[string]string{"tier": "frontend"}),
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "auth-abc12",
+				Namespace: "staging",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "auth"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.244.2.5"}}},
+		},
+	)
+	k8sClient := NewK8sServiceClient(clientset, metav1.NamespaceAll)
+
+	t.Run("GetAllServices", func(t *testing.T) {
+		services, err := k8sClient.GetAllServices()
+		assert.NoError(t, err)
+		assert.Len(t, services, 3)
+		for _, svc := range services {
+			if svc.ID() == (ServiceID{Namespace: "prod", Name: "auth"}) {
+				assert.Equal(t, []string{"10.96.1.10"}, svc.IPs, "endpoint slices stay with their own namespace")
+			}
+		}
+	})
+
+	t.Run("LabelSelector", func(t *testing.T) {
+		client := NewK8sServiceClient(clientset, metav1.NamespaceAll).WithSelectors(Selectors{Label: "tier=backend"})
+		services, err := client.GetAllServices()
+		assert.NoError(t, err)
+		assert.Len(t, services, 2)
+	})
+
+	t.Run("GetService", func(t *testing.T) {
+		svc, err := k8sClient.GetService("web")
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", svc.Namespace)
+
+		_, err = k8sClient.GetService("auth")
+		assert.Error(t, err)
+
+		svc, err = k8sClient.GetNamespacedService("staging", "auth")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.96.2.10", "10.244.2.5"}, svc.IPs)
+	})
+}
+
+func TestScopeContains(t *testing.T) {
+	svc := ServiceData{Name: "auth", Namespace: "prod", Labels: map[string]string{"tier": "backend"}}
+	tests := []struct {
+		name  string
+		scope Scope
+		want  bool
+	}{
+		{"Everything", Scope{}, true},
+		{"Namespace", Scope{Namespace: "prod"}, true},
+		{"OtherNamespace", Scope{Namespace: "staging"}, false},
+		{"Label", Scope{Selectors: Selectors{Label: "tier=backend"}}, true},
+		{"OtherLabel", Scope{Selectors: Selectors{Label: "tier=frontend"}}, false},
+		{"Field", Scope{Selectors: Selectors{Field: "metadata.name=auth"}}, true},
+		{"OtherField", Scope{Selectors: Selectors{Field: "metadata.name!=auth"}}, false},
+		{"UnknownField", Scope{Selectors: Selectors{Field: "spec.type=ClusterIP"}}, false},
+		{"InvalidLabel", Scope{Selectors: Selectors{Label: "tier in"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.scope.Contains(svc))
+		})
+	}
+}
@@ -1,61 +1,120 @@
 package routes
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"net/netip"
+	"time"
 
 	"example.com/m/internal/database"
+	"example.com/m/internal/network"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Options holds server-side defaults for parameters a request may omit.
+type Options struct {
+	Database          string
+	NetworkCollection string
+	ServiceCollection string
+	// ReadinessChecks are reported by /readyz, keyed by name. The server is
+	// ready once every check returns nil.
+	ReadinessChecks map[string]func() error
+}
+
+// Handler serves the API endpoints using a shared data store.
+type Handler struct {
+	store database.Repository
+	opts  Options
+}
+
+// NewHandler creates a Handler backed by the given store.
+func NewHandler(store database.Repository, opts Options) *Handler {
+	return &Handler{store: store, opts: opts}
+}
+
+// trafficServiceRequest is the body accepted by POST /TrafficService.
+type trafficServiceRequest struct {
+	Database          string `json:"database"`
+	NetworkCollection string `json:"networkCollection"`
+	ServiceCollection string `json:"serviceCollection"`
+	ServiceName       string `json:"serviceName"`
+	// ServiceNamespace picks the queried service's namespace, needed when
+	// its name is used in several.
+	ServiceNamespace  string    `json:"serviceNamespace"`
+	From              time.Time `json:"from"`
+	To                time.Time `json:"to"`
+	Limit             int       `json:"limit"`
+	SortBy            string    `json:"sortBy"`
+	Order             string    `json:"order"`
+	ContinuationToken string    `json:"continuationToken"`
+
+	// Optional filters; omitted fields match every flow.
+	Statuses         []string `json:"statuses"`
+	SourcePort       int      `json:"sourcePort"`
+	DestinationPort  int      `json:"destinationPort"`
+	SourceCIDRs      []string `json:"sourceCIDRs"`
+	DestinationCIDRs []string `json:"destinationCIDRs"`
+	Direction        string   `json:"direction"`
+	Protocol         string   `json:"protocol"`
+	// Namespace keeps only flows with a service from that namespace on
+	// either side, as it does on every other route.
+	Namespace string `json:"namespace"`
+}
+
 // API endpoint handler
 // HTTP handler for the endpoint
-func GetTrafficWithService(w http.ResponseWriter, r *http.Request) {
-	var body map[string]interface{}
+func (h *Handler) GetTrafficWithService(w http.ResponseWriter, r *http.Request) {
+	var body trafficServiceRequest
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	db, ok := body["database"].(string)
-	if !ok || db == "" {
-		http.Error(w, "Missing or invalid 'database' parameter", http.StatusBadRequest)
-		return
-	}
-	networkCollection, ok := body["networkCollection"].(string)
-	if !ok || networkCollection == "" {
-		http.Error(w, "Missing or invalid 'networkCollection' parameter", http.StatusBadRequest)
+	collections, err := h.collections(body.Database, body.NetworkCollection, body.ServiceCollection)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	serviceName, ok := body["serviceName"].(string)
-	if !ok || networkCollection == "" {
+	if body.ServiceName == "" {
 		http.Error(w, "Missing or invalid 'serviceName' parameter", http.StatusBadRequest)
 		return
 	}
 
-	mongoURI := os.Getenv("MONGO_URI")
-
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
+	if !body.From.IsZero() && !body.To.IsZero() && !body.From.Before(body.To) {
+		http.Error(w, "Invalid time range: 'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(body.Limit, body.SortBy, body.Order, body.ContinuationToken)
 	if err != nil {
-		http.Error(w, "Invalid Client Connection", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	ctx := context.Background()
-	if err := client.Connect(ctx); err != nil {
-		http.Error(w, "Failed to connect into MongoDB", http.StatusInternalServerError)
+	query := database.TrafficQuery{
+		Collections: collections,
+		ServiceName: body.ServiceName,
+		Namespace:   body.ServiceNamespace,
+		From:        body.From,
+		To:          body.To,
+		Filter:      filter,
+		Page:        page,
+	}
+	if acceptsNDJSON(r) {
+		h.streamTraffic(w, r, query)
 		return
 	}
-	defer client.Disconnect(ctx)
-	results, err := database.AggregateTrafficWithService(ctx, client, db, networkCollection, serviceName)
+
+	results, err := h.store.AggregateTrafficWithService(r.Context(), query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error running aggregation query: %v", err), http.StatusInternalServerError)
+		writeQueryError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -65,11 +124,126 @@ func GetTrafficWithService(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// collections fills in the server defaults for any of the database and
+// collection parameters a request omitted.
+func (h *Handler) collections(db, networkCollection, serviceCollection string) (database.Collections, error) {
+	c := database.Collections{
+		Database:          firstNonEmpty(db, h.opts.Database),
+		NetworkCollection: firstNonEmpty(networkCollection, h.opts.NetworkCollection),
+		ServiceCollection: firstNonEmpty(serviceCollection, h.opts.ServiceCollection),
+	}
+	switch {
+	case c.Database == "":
+		return c, errors.New("Missing or invalid 'database' parameter")
+	case c.NetworkCollection == "":
+		return c, errors.New("Missing or invalid 'networkCollection' parameter")
+	case c.ServiceCollection == "":
+		return c, errors.New("Missing or invalid 'serviceCollection' parameter")
+	}
+	return c, nil
+}
+
+// parsePage validates the pagination and sorting parameters of a request.
+func parsePage(limit int, sortBy, order, token string) (database.Page, error) {
+	if limit < 0 || limit > database.MaxPageSize {
+		return database.Page{}, fmt.Errorf("Invalid 'limit' parameter: must be between 1 and %d", database.MaxPageSize)
+	}
+	sortField, err := database.ParseSortField(sortBy)
+	if err != nil {
+		return database.Page{}, fmt.Errorf("Invalid 'sortBy' parameter: %v", err)
+	}
+	var descending bool
+	switch order {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return database.Page{}, fmt.Errorf("Invalid 'order' parameter %q: must be asc or desc", order)
+	}
+	return database.Page{Limit: limit, Sort: sortField, Descending: descending, After: token}, nil
+}
+
+// parseFilter validates the filter fields of a traffic request.
+func parseFilter(body trafficServiceRequest) (database.TrafficFilter, error) {
+	var filter database.TrafficFilter
+	for _, value := range body.Statuses {
+		status, err := network.ParseTrafficStatus(value)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid 'statuses' parameter: %v", err)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	if body.SourcePort < 0 || body.SourcePort > 65535 {
+		return filter, errors.New("Invalid 'sourcePort' parameter: must be between 1 and 65535, or 0 for any port")
+	}
+	if body.DestinationPort < 0 || body.DestinationPort > 65535 {
+		return filter, errors.New("Invalid 'destinationPort' parameter: must be between 1 and 65535, or 0 for any port")
+	}
+	filter.SourcePort, filter.DestinationPort = body.SourcePort, body.DestinationPort
+
+	var err error
+	if filter.SourceCIDRs, err = parseCIDRs(body.SourceCIDRs); err != nil {
+		return filter, fmt.Errorf("Invalid 'sourceCIDRs' parameter: %v", err)
+	}
+	if filter.DestinationCIDRs, err = parseCIDRs(body.DestinationCIDRs); err != nil {
+		return filter, fmt.Errorf("Invalid 'destinationCIDRs' parameter: %v", err)
+	}
+	if filter.Direction, err = database.ParseDirection(body.Direction); err != nil {
+		return filter, fmt.Errorf("Invalid 'direction' parameter: %v", err)
+	}
+
+	if filter.Protocol, err = network.ParseProtocol(body.Protocol); err != nil {
+		return filter, fmt.Errorf("Invalid 'protocol' parameter: %v", err)
+	}
+	filter.Namespace = body.Namespace
+	return filter, nil
+}
+
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		prefix, err := database.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// writeQueryError maps data layer errors to HTTP status codes.
+func writeQueryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrCollectionNotFound), errors.Is(err, database.ErrServiceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrAmbiguousService):
+		http.Error(w, fmt.Sprintf("%v; pass a serviceNamespace", err), http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("Error running aggregation query: %v", err), http.StatusInternalServerError)
+	}
+}
+
 // SetupRouter with CORS enabled
-func SetupRouter() http.Handler {
+func SetupRouter(h *Handler) http.Handler {
 	r := mux.NewRouter()
-	r.HandleFunc("/TrafficService", GetTrafficWithService).Methods("POST")
-	r.Use(QueryParamsToBodyMiddleware)
+	r.Handle("/TrafficService", QueryParamsToBodyMiddleware(http.HandlerFunc(h.GetTrafficWithService))).Methods("POST")
+	r.HandleFunc("/traffic", h.GetTrafficByAddress).Methods("GET")
+	r.HandleFunc("/traffic/ingest", h.IngestTraffic).Methods("POST")
+	r.HandleFunc("/graph", h.GetServiceGraph).Methods("GET")
+	r.HandleFunc("/services/{name}/stats", h.GetServiceStats).Methods("GET")
+	r.HandleFunc("/endpoints/unknown", h.GetUnknownEndpoints).Methods("GET")
+	r.HandleFunc("/readyz", h.GetReadiness).Methods("GET")
 
 	// Set up CORS middleware
 	corsHandler := handlers.CORS(
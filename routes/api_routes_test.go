@@ -2,17 +2,42 @@ package routes
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
+	"example.com/m/internal/database"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
+// newSampleHandler builds a Handler over an in-memory store seeded with the
+// sample fixtures.
+func newSampleHandler(t *testing.T) *Handler {
+	t.Helper()
+	store := database.NewMemoryStore()
+	if err := store.LoadTrafficFile("testdb", "testcollectionB", "../sample/networkData"); err != nil {
+		t.Fatalf("Failed to load network fixture: %v", err)
+	}
+	if err := store.LoadServiceFile("testdb", "testcollectionA", "../sample/serviceData"); err != nil {
+		t.Fatalf("Failed to load service fixture: %v", err)
+	}
+	return NewHandler(store, Options{
+		Database:          "testdb",
+		NetworkCollection: "testcollectionB",
+		ServiceCollection: "testcollectionA",
+	})
+}
+
 func TestAPIRoute(t *testing.T) {
+	handler := newSampleHandler(t)
+
 	t.Run("Success", func(t *testing.T) {
 		requestBody := map[string]interface{}{
 			"database":          "testdb",
@@ -30,16 +55,256 @@ func TestAPIRoute(t *testing.T) {
 		}
 
 		rr := httptest.NewRecorder()
-		r := SetupRouter()
+		r := SetupRouter(handler)
 		r.ServeHTTP(rr, req)
 
 		assert.Equal(t, http.StatusOK, rr.Code, "Expected status code 200")
 
-		var response []map[string]interface{}
+		var response struct {
+			Items             []map[string]interface{} `json:"items"`
+			Total             int                      `json:"total"`
+			ContinuationToken string                   `json:"continuationToken"`
+		}
 		err = json.NewDecoder(rr.Body).Decode(&response)
 		log.Debug().Msgf("%+v", response)
 		assert.NoError(t, err, "Failed to decode response body")
-		assert.Greater(t, len(response), 0, "Expected non-empty response")
+		assert.Greater(t, len(response.Items), 0, "Expected non-empty response")
+		assert.Equal(t, len(response.Items), response.Total)
+		assert.Equal(t, "10.128.72.14", response.Items[0]["destination_ip"])
+	})
+
+	t.Run("UnknownServiceCollection", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{
+			"database":          "testdb",
+			"networkCollection": "testcollectionB",
+			"serviceCollection": "inventory",
+			"serviceName":       "Gaming UI",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("UnknownService", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{
+			"database":          "testdb",
+			"networkCollection": "testcollectionB",
+			"serviceName":       "Nope",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("MissingServiceName", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{
+			"database":          "testdb",
+			"networkCollection": "testcollectionB",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{
+			"serviceName": "Login Service",
+			"limit":       2,
+			"sortBy":      "source_port",
+			"order":       "desc",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Items []struct {
+				SourcePort int `json:"source_port"`
+			} `json:"items"`
+			Total             int    `json:"total"`
+			ContinuationToken string `json:"continuationToken"`
+		}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, 4, response.Total)
+		assert.Len(t, response.Items, 2)
+		assert.Equal(t, 54959, response.Items[0].SourcePort)
+		assert.NotEmpty(t, response.ContinuationToken)
+	})
+
+	t.Run("InvalidPagination", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"serviceName": "Login Service", "limit": -1},
+			{"serviceName": "Login Service", "sortBy": "bytes"},
+			{"serviceName": "Login Service", "order": "sideways"},
+			{"serviceName": "Login Service", "continuationToken": "not-a-token"},
+		} {
+			req, err := createRequest("POST", "/TrafficService", body)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "body %v", body)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			body  map[string]interface{}
+			total int
+		}{
+			{"CriticalFromSubnet", map[string]interface{}{
+				"serviceName": "User Profile DB", "statuses": []string{"Warning", "Critical"},
+				"destinationPort": 5432, "sourceCIDRs": []string{"10.128.0.0/16"},
+			}, 1},
+			{"OtherSubnet", map[string]interface{}{
+				"serviceName": "User Profile DB", "sourceCIDRs": []string{"192.168.0.0/16"},
+			}, 0},
+			{"Outbound", map[string]interface{}{"serviceName": "Gaming UI", "direction": "outbound"}, 1},
+			{"InboundFromHost", map[string]interface{}{
+				"serviceName": "Gaming UI", "direction": "inbound", "sourceCIDRs": []string{"40.196.163.209"}, "protocol": "tcp",
+			}, 1},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				req, err := createRequest("POST", "/TrafficService", tc.body)
+				assert.NoError(t, err)
+
+				rr := httptest.NewRecorder()
+				SetupRouter(handler).ServeHTTP(rr, req)
+				assert.Equal(t, http.StatusOK, rr.Code)
+
+				var response struct {
+					Total int `json:"total"`
+				}
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+				assert.Equal(t, tc.total, response.Total)
+			})
+		}
+	})
+
+	t.Run("InvalidFilters", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"serviceName": "Login Service", "statuses": []string{"Broken"}},
+			{"serviceName": "Login Service", "destinationPort": 70000},
+			{"serviceName": "Login Service", "sourceCIDRs": []string{"10.0.0.0/33"}},
+			{"serviceName": "Login Service", "destinationCIDRs": []string{"fd00::/8"}},
+			{"serviceName": "Login Service", "direction": "sideways"},
+			{"serviceName": "Login Service", "protocol": "carrier-pigeon"},
+		} {
+			req, err := createRequest("POST", "/TrafficService", body)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "body %v", body)
+		}
+	})
+
+	t.Run("TimeRange", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService?from=2024-11-20T09:30:00Z&to=2024-11-20T10:27:20Z", map[string]interface{}{
+			"serviceName": "Login Service",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Total int `json:"total"`
+		}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, 2, response.Total)
+	})
+
+	t.Run("TypedQueryParams", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService?limit=1&statuses=OK,Warning&destinationPort=443", map[string]interface{}{
+			"serviceName": "Login Service",
+		})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var response struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Len(t, response.Items, 1)
+
+		req, err = createRequest("POST", "/TrafficService?limit=ten", map[string]interface{}{"serviceName": "Login Service"})
+		assert.NoError(t, err)
+		rr = httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("InvalidTimeRange", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"serviceName": "Login Service", "from": "yesterday"},
+			{"serviceName": "Login Service", "from": "2024-11-20T10:00:00Z", "to": "2024-11-20T09:00:00Z"},
+		} {
+			req, err := createRequest("POST", "/TrafficService", body)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "body %v", body)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{
+			"serviceName": "Gaming UI",
+			"sortBy":      "status",
+			"order":       "desc",
+		})
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		assert.True(t, rr.Flushed)
+
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		assert.Len(t, lines, 3)
+		var first map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, "Critical", first["status"])
+	})
+
+	t.Run("NDJSONUnknownService", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{"serviceName": "Nope"})
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("NDJSONClientGone", func(t *testing.T) {
+		req, err := createRequest("POST", "/TrafficService", map[string]interface{}{"serviceName": "Gaming UI"})
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+		ctx, cancel := context.WithCancel(req.Context())
+		cancel()
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req.WithContext(ctx))
+		assert.Empty(t, rr.Body.String())
 	})
 
 	t.Run("QueryParamsToBodyMiddleware", func(t *testing.T) {
@@ -67,4 +332,17 @@ func TestAPIRoute(t *testing.T) {
 		assert.Equal(t, http.StatusOK, rr.Code)
 	})
 
+	t.Run("MergeQueryParams", func(t *testing.T) {
+		target := reflect.TypeOf(trafficServiceRequest{})
+		body := []byte(`{"serviceName":"Auth","limit":9007199254740993}`)
+
+		merged, err := mergeQueryParams(body, url.Values{"other": {"x"}}, target)
+		assert.NoError(t, err)
+		assert.Equal(t, body, merged, "bodies are not re-encoded when no field is added")
+
+		merged, err = mergeQueryParams(body, url.Values{"sourcePort": {"80"}, "statuses": {"OK", "Critical"}, "limit": {"5"}}, target)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"serviceName":"Auth","limit":9007199254740993,"sourcePort":80,"statuses":["OK","Critical"]}`, string(merged))
+		assert.Contains(t, string(merged), "9007199254740993", "numbers keep their precision")
+	})
 }
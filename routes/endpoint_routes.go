@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"example.com/m/internal/database"
+)
+
+// GetUnknownEndpoints lists the IP addresses seen in the network collection
+// that no service in the inventory claims, split into internal and external
+// addresses. The database and collections can be overridden with query
+// parameters, and a `namespace` parameter only scans flows with a service
+// from that namespace on either side.
+func (h *Handler) GetUnknownEndpoints(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	collections, err := h.collections(query.Get("database"), query.Get("networkCollection"), query.Get("serviceCollection"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	endpoints, err := h.store.FindUnknownEndpoints(r.Context(), database.FlowQuery{Collections: collections, Namespace: query.Get("namespace")})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(endpoints); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/m/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownEndpointsRoute(t *testing.T) {
+	handler := newSampleHandler(t)
+
+	t.Run("Success", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/endpoints/unknown", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.UnknownEndpoints
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Empty(t, response.Internal)
+		assert.Len(t, response.External, 5)
+	})
+
+	t.Run("UnknownCollection", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/endpoints/unknown?networkCollection=missing", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/graph"
+)
+
+// Output formats supported by the graph endpoint, with their content types.
+const (
+	graphFormatJSON    = "json"
+	graphFormatDOT     = "dot"
+	graphFormatMermaid = "mermaid"
+
+	contentTypeDOT     = "text/vnd.graphviz"
+	contentTypeMermaid = "text/vnd.mermaid"
+)
+
+// GetServiceGraph returns the service dependency graph built from the whole
+// network collection. The database and collections can be overridden with
+// query parameters, a `namespace` parameter keeps only flows with a service
+// from that namespace on either side, and the output format is chosen with
+// the `format` query parameter or the Accept header.
+func (h *Handler) GetServiceGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := graphFormat(query.Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collections, err := h.collections(query.Get("database"), query.Get("networkCollection"), query.Get("serviceCollection"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flows, err := h.store.SummarizeFlows(r.Context(), database.FlowQuery{Collections: collections, Namespace: query.Get("namespace")})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	g := graph.Build(flows)
+
+	switch format {
+	case graphFormatDOT:
+		w.Header().Set("Content-Type", contentTypeDOT+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, g.DOT())
+	case graphFormatMermaid:
+		w.Header().Set("Content-Type", contentTypeMermaid+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, g.Mermaid())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(g); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+		}
+	}
+}
+
+// graphFormat picks the output format. An explicit format parameter wins;
+// otherwise the Accept header is consulted and JSON is the fallback.
+func graphFormat(param, accept string) (string, error) {
+	switch strings.ToLower(param) {
+	case graphFormatJSON, graphFormatDOT, graphFormatMermaid:
+		return strings.ToLower(param), nil
+	case "":
+	default:
+		return "", fmt.Errorf("Unsupported 'format' parameter %q", param)
+	}
+
+	for _, mediaType := range strings.Split(accept, ",") {
+		mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+		switch mediaType {
+		case contentTypeDOT:
+			return graphFormatDOT, nil
+		case contentTypeMermaid:
+			return graphFormatMermaid, nil
+		case "application/json":
+			return graphFormatJSON, nil
+		}
+	}
+	return graphFormatJSON, nil
+}
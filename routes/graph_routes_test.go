@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/m/internal/graph"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphRoute(t *testing.T) {
+	handler := newSampleHandler(t)
+
+	t.Run("Success", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/graph?database=testdb&networkCollection=testcollectionB", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response graph.Graph
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Len(t, response.Nodes, 6)
+		assert.Len(t, response.Edges, 5)
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		cases := []struct {
+			name        string
+			url         string
+			accept      string
+			contentType string
+			prefix      string
+		}{
+			{"DOTParam", "/graph?format=dot", "", "text/vnd.graphviz; charset=utf-8", "digraph services {"},
+			{"MermaidParam", "/graph?format=mermaid", "", "text/vnd.mermaid; charset=utf-8", "flowchart LR"},
+			{"DOTAccept", "/graph", "text/vnd.graphviz", "text/vnd.graphviz; charset=utf-8", "digraph services {"},
+			{"ParamOverridesAccept", "/graph?format=json", "text/vnd.mermaid", "application/json", "{"},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				req, err := http.NewRequest("GET", tc.url, nil)
+				assert.NoError(t, err)
+				req.Header.Set("Accept", tc.accept)
+
+				rr := httptest.NewRecorder()
+				SetupRouter(handler).ServeHTTP(rr, req)
+				assert.Equal(t, http.StatusOK, rr.Code)
+				assert.Equal(t, tc.contentType, rr.Header().Get("Content-Type"))
+				assert.True(t, strings.HasPrefix(rr.Body.String(), tc.prefix))
+			})
+		}
+	})
+
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/graph?format=png", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("UnknownCollection", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/graph?database=testdb&networkCollection=missing", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
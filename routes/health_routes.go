@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// readinessResponse reports the result of each readiness check, "ok" or the
+// reason it failed.
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// GetReadiness responds 200 when every readiness check passes, such as the
+// service cache having synced, and 503 otherwise.
+func (h *Handler) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	response := readinessResponse{Status: "ok"}
+	status := http.StatusOK
+	if len(h.opts.ReadinessChecks) > 0 {
+		response.Checks = make(map[string]string, len(h.opts.ReadinessChecks))
+	}
+	for name, check := range h.opts.ReadinessChecks {
+		if err := check(); err != nil {
+			response.Checks[name] = err.Error()
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+	}
+}
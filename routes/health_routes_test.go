@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/m/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessRoute(t *testing.T) {
+	synced := false
+	handler := NewHandler(database.NewMemoryStore(), Options{
+		ReadinessChecks: map[string]func() error{
+			"service-cache": func() error {
+				if !synced {
+					return errors.New("service cache has not synced")
+				}
+				return nil
+			},
+		},
+	})
+
+	readyz := func() (int, readinessResponse) {
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+
+		var response readinessResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		return rr.Code, response
+	}
+
+	t.Run("NotReady", func(t *testing.T) {
+		code, response := readyz()
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "unavailable", response.Status)
+		assert.Equal(t, "service cache has not synced", response.Checks["service-cache"])
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		synced = true
+		code, response := readyz()
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", response.Status)
+		assert.Equal(t, "ok", response.Checks["service-cache"])
+	})
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/network"
+)
+
+const (
+	// maxIngestBytes caps the size of a single ingestion request body.
+	maxIngestBytes = 32 << 20
+	// maxIngestRecords caps the number of records in a single ingestion request.
+	maxIngestRecords = 10000
+)
+
+// ingestResponse reports the outcome of POST /traffic/ingest.
+type ingestResponse struct {
+	Received int                    `json:"received"`
+	Inserted int                    `json:"inserted"`
+	Rejected int                    `json:"rejected"`
+	Errors   []database.RecordError `json:"errors"`
+}
+
+// IngestTraffic stores a batch of network traffic records sent either as a
+// JSON array or as NDJSON, one record per line. Each record is decoded and
+// validated on its own: invalid records are reported by their position in
+// the batch and the rest are written. A record must say when it was
+// observed; one without an observed_at time is rejected, since the time of
+// ingestion can be far from when the flow happened. Records are always
+// written to the server's configured database and network collection.
+func (h *Handler) IngestTraffic(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.collections("", "", "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ingestion is not configured: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	elements, err := readIngestBody(http.MaxBytesReader(w, r.Body, maxIngestBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(elements) > maxIngestRecords {
+		http.Error(w, fmt.Sprintf("Too many records: at most %d may be sent at once", maxIngestRecords), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	response := ingestResponse{Received: len(elements), Errors: []database.RecordError{}}
+	var records []network.NetworkTraffic
+	var positions []int
+	for i, element := range elements {
+		var record network.NetworkTraffic
+		if err := json.Unmarshal(element, &record); err != nil {
+			response.Errors = append(response.Errors, database.RecordError{Index: i, Error: err.Error()})
+			continue
+		}
+		if record.ObservedTime().IsZero() {
+			response.Errors = append(response.Errors, database.RecordError{Index: i, Error: "missing observed_at time"})
+			continue
+		}
+		records = append(records, record)
+		positions = append(positions, i)
+	}
+
+	failures, err := h.store.InsertTrafficBatch(r.Context(), collections.Database, collections.NetworkCollection, records)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error writing traffic data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, failure := range failures {
+		failure.Index = positions[failure.Index]
+		response.Errors = append(response.Errors, failure)
+	}
+	sort.Slice(response.Errors, func(i, j int) bool { return response.Errors[i].Index < response.Errors[j].Index })
+	response.Rejected = len(response.Errors)
+	response.Inserted = response.Received - response.Rejected
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// readIngestBody splits a JSON array or a stream of JSON values into its
+// elements without decoding them, so a malformed record can be reported on
+// its own. Invalid JSON syntax fails the whole body.
+func readIngestBody(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, errors.New("no records")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	var elements []json.RawMessage
+	if first == '[' {
+		if err := decoder.Decode(&elements); err != nil {
+			return nil, err
+		}
+		return elements, nil
+	}
+	for {
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err == io.EOF {
+			return elements, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(elements), err)
+		}
+		elements = append(elements, element)
+	}
+}
+
+// peekNonSpace returns the first byte of the body that is not whitespace
+// without consuming it.
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsRune([]byte(" \t\r\n"), rune(b[0])) {
+			return b[0], nil
+		}
+		if _, err := reader.Discard(1); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngestRoute(t *testing.T) {
+	ingest := func(t *testing.T, handler *Handler, contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/traffic/ingest", strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("JSONArray", func(t *testing.T) {
+		handler := newSampleHandler(t)
+		rr := ingest(t, handler, "application/json", `[
+			{"source_ip": "10.128.99.5", "source_port": 51000, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK", "observed_at": "2024-11-21T08:00:00Z"},
+			{"source_ip": "10.128.99.5", "source_port": 51001, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "Broken", "observed_at": "2024-11-21T08:00:00Z"},
+			{"source_ip": "10.128.99", "source_port": 51002, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK", "observed_at": "2024-11-21T08:00:00Z"},
+			{"source_ip": "10.128.99.5", "source_port": 51003, "destination_ip": "10.128.72.69", "destination_port": 70000, "status": "OK", "observed_at": "2024-11-21T08:00:00Z"},
+			{"source_ip": "10.128.99.5", "source_port": 51004, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK"}
+		]`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response ingestResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, 5, response.Received)
+		assert.Equal(t, 1, response.Inserted)
+		assert.Equal(t, 4, response.Rejected)
+		if assert.Len(t, response.Errors, 4) {
+			assert.Equal(t, 1, response.Errors[0].Index)
+			assert.Contains(t, response.Errors[0].Error, "status")
+			assert.Equal(t, 2, response.Errors[1].Index)
+			assert.Equal(t, 3, response.Errors[2].Index)
+			assert.Equal(t, 4, response.Errors[3].Index)
+			assert.Contains(t, response.Errors[3].Error, "observed_at")
+		}
+
+		records, err := handler.store.FindTraffic(context.Background(), "testdb", "testcollectionB")
+		assert.NoError(t, err)
+		assert.Len(t, records, 9)
+	})
+
+	t.Run("IgnoresCollectionParameters", func(t *testing.T) {
+		handler := newSampleHandler(t)
+		req, err := http.NewRequest("POST", "/traffic/ingest?database=otherdb&networkCollection=other", strings.NewReader(
+			`{"source_ip": "10.128.99.5", "source_port": 51000, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK", "observed_at": "2024-11-21T08:00:00Z"}`))
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		records, err := handler.store.FindTraffic(context.Background(), "testdb", "testcollectionB")
+		assert.NoError(t, err)
+		assert.Len(t, records, 9)
+		other, _ := handler.store.FindTraffic(context.Background(), "otherdb", "other")
+		assert.Empty(t, other)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		handler := newSampleHandler(t)
+		rr := ingest(t, handler, "application/x-ndjson",
+			`{"source_ip": "10.128.99.5", "source_port": 51000, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK", "observed_at": "2024-11-21T08:00:00Z"}`+"\n"+
+				`{"source_ip": "10.128.99.5", "source_port": "51001", "destination_ip": "10.128.72.69", "destination_port": 443, "status": "OK"}`+"\n"+
+				`{"source_ip": "10.128.99.6", "source_port": 51002, "destination_ip": "10.128.72.69", "destination_port": 443, "status": "Warning", "observed_at": "2024-11-21T08:00:05Z"}`+"\n")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response ingestResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, 3, response.Received)
+		assert.Equal(t, 2, response.Inserted)
+		if assert.Len(t, response.Errors, 1) {
+			assert.Equal(t, 1, response.Errors[0].Index)
+		}
+	})
+
+	t.Run("MalformedBody", func(t *testing.T) {
+		handler := newSampleHandler(t)
+		for _, body := range []string{"", "[{", `{"source_ip": "10.0.0.1"} {`} {
+			rr := ingest(t, handler, "application/json", body)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		}
+	})
+}
@@ -7,9 +7,14 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
-// Middleware to copy query parameters into the body
+// QueryParamsToBodyMiddleware copies the query parameters of a
+// /TrafficService request into its JSON body, so every body field can also
+// be passed in the query string.
 func QueryParamsToBodyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		baseURL := "http://localhost:8080"
@@ -22,18 +27,95 @@ func QueryParamsToBodyMiddleware(next http.Handler) http.Handler {
 		if r.URL.Host == "" {
 			r.URL.Host = parsedURL.Host
 		}
-		bodyBytes, _ := io.ReadAll(r.Body)
-		queryParams := r.URL.Query()
-		for key, values := range queryParams {
-			if len(values) > 0 {
-				bodyBytes = append(bodyBytes, []byte(fmt.Sprintf("\"%s\":\"%s\"", key, values[0]))...)
-			}
+		var bodyBytes []byte
+		if r.Body != nil {
+			bodyBytes, _ = io.ReadAll(r.Body)
+		}
+		bodyBytes, err = mergeQueryParams(bodyBytes, r.URL.Query(), reflect.TypeOf(trafficServiceRequest{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		r.ContentLength = int64(len(bodyBytes))
 		next.ServeHTTP(w, r)
 	})
 }
 
+// mergeQueryParams adds the query parameters naming a field of target, a
+// struct type, to a JSON object body. Each value is converted to the JSON
+// type of its field: numbers stay numbers, list fields take repeated or
+// comma-separated values. Fields already present in the body win over the
+// query string, and parameters not naming a field are ignored. The body is
+// returned unchanged when nothing is added or when it is not a JSON object.
+func mergeQueryParams(bodyBytes []byte, queryParams url.Values, target reflect.Type) ([]byte, error) {
+	if len(queryParams) == 0 {
+		return bodyBytes, nil
+	}
+
+	// Raw values keep the body's numbers exactly as they were sent.
+	body := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(bodyBytes)) > 0 {
+		if err := json.Unmarshal(bodyBytes, &body); err != nil || body == nil {
+			return bodyBytes, nil
+		}
+	}
+
+	added := false
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		values := queryParams[key]
+		if key == "" || key == "-" || len(values) == 0 {
+			continue
+		}
+		if _, exists := body[key]; exists {
+			continue
+		}
+		value, err := queryValue(field.Type, values)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid '%s' parameter: %v", key, err)
+		}
+		body[key] = value
+		added = true
+	}
+	if !added {
+		return bodyBytes, nil
+	}
+	return json.Marshal(body)
+}
+
+// queryValue encodes query string values as JSON of the given field type.
+func queryValue(fieldType reflect.Type, values []string) (json.RawMessage, error) {
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", values[0])
+		}
+		return json.RawMessage(strconv.FormatInt(n, 10)), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", values[0])
+		}
+		return json.Marshal(b)
+	case reflect.Slice:
+		var items []string
+		for _, value := range values {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		return json.Marshal(items)
+	default:
+		// Strings, and types such as time.Time that decode from a JSON string.
+		return json.Marshal(values[0])
+	}
+}
+
 // Helper function to create a request with JSON body
 func createRequest(method, url string, body interface{}) (*http.Request, error) {
 	bodyBytes, err := json.Marshal(body)
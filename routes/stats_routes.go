@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/m/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// serviceStatsResponse is the body returned by GET /services/{name}/stats.
+type serviceStatsResponse struct {
+	Service   string                   `json:"service"`
+	Namespace string                   `json:"namespace,omitempty"`
+	Bucket    database.BucketSize      `json:"bucket"`
+	Buckets   []database.TrafficBucket `json:"buckets"`
+}
+
+// GetServiceStats returns the number of flows to or from a service per time
+// bucket and status. The bucket size (minute, hour or day) and an RFC 3339
+// from/to range are read from query parameters, as are the service's
+// namespace (serviceNamespace), needed when its name is used in several,
+// and the database and collection overrides.
+func (h *Handler) GetServiceStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	collections, err := h.collections(query.Get("database"), query.Get("networkCollection"), query.Get("serviceCollection"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bucket, err := database.ParseBucketSize(query.Get("bucket"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'bucket' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'from' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'to' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		http.Error(w, "Invalid time range: 'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+
+	name, namespace := mux.Vars(r)["name"], query.Get("serviceNamespace")
+	buckets, err := h.store.TrafficStats(r.Context(), database.StatsQuery{
+		Collections: collections,
+		ServiceName: name,
+		Namespace:   namespace,
+		Bucket:      bucket,
+		From:        from,
+		To:          to,
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(serviceStatsResponse{Service: name, Namespace: namespace, Bucket: bucket, Buckets: buckets}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// parseTimeParam parses an optional RFC 3339 timestamp; an empty value is the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
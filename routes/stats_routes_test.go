@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/m/internal/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsRoute(t *testing.T) {
+	handler := newSampleHandler(t)
+
+	t.Run("Success", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/services/Gaming%20UI/stats?bucket=day&to=2024-11-20T11:00:00Z", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response serviceStatsResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, "Gaming UI", response.Service)
+		assert.Equal(t, "day", string(response.Bucket))
+		if assert.Len(t, response.Buckets, 1) {
+			assert.Equal(t, 2, response.Buckets[0].Total)
+			assert.Equal(t, 2, response.Buckets[0].Statuses[network.StatusOK])
+		}
+	})
+
+	t.Run("DefaultsToHourlyBuckets", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/services/Gaming%20UI/stats", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response serviceStatsResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, "hour", string(response.Bucket))
+		assert.Len(t, response.Buckets, 3)
+	})
+
+	t.Run("InvalidParameters", func(t *testing.T) {
+		for _, url := range []string{
+			"/services/Gaming%20UI/stats?bucket=week",
+			"/services/Gaming%20UI/stats?from=yesterday",
+			"/services/Gaming%20UI/stats?from=2024-11-21T00:00:00Z&to=2024-11-20T00:00:00Z",
+		} {
+			req, err := http.NewRequest("GET", url, nil)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+		}
+	})
+
+	t.Run("UnknownService", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/services/Nope/stats", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"example.com/m/internal/database"
+	"github.com/rs/zerolog/log"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the client asked for newline-delimited JSON.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, mediaType := range strings.Split(r.Header.Get("Accept"), ",") {
+		if parsed, _, err := mime.ParseMediaType(strings.TrimSpace(mediaType)); err == nil && parsed == contentTypeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// streamTraffic writes every row of the query as one JSON document per line,
+// flushing as rows arrive so large result sets are never held in memory.
+// The stream stops as soon as the client goes away.
+func (h *Handler) streamTraffic(w http.ResponseWriter, r *http.Request, query database.TrafficQuery) {
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// Headers are only sent with the first row, so errors raised before any
+	// data is read (unknown service, missing collection) still get a proper
+	// status code.
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	err := h.store.StreamTrafficWithService(r.Context(), query, func(row database.ServiceTraffic) error {
+		if !started {
+			start()
+		}
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		if !started {
+			start()
+		}
+	case r.Context().Err() != nil:
+		log.Debug().Err(err).Msg("client disconnected during traffic stream")
+	case !started:
+		writeQueryError(w, err)
+	default:
+		// The status line has already been sent, so all that is left is to
+		// end the stream early and record why.
+		log.Error().Err(err).Msg("traffic stream aborted")
+	}
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+
+	"example.com/m/internal/database"
+)
+
+// GetTrafficByAddress returns the flows to or from an IP address (`ip`) or
+// address range (`cidr`), enriched with whatever service is known on either
+// side. Unlike /TrafficService it does not need the address to belong to a
+// registered service. Time range, pagination and NDJSON streaming work as
+// they do for /TrafficService, with the parameters read from the query string.
+// A `namespace` parameter keeps only flows with a service from that namespace
+// on either side.
+func (h *Handler) GetTrafficByAddress(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	collections, err := h.collections(params.Get("database"), params.Get("networkCollection"), params.Get("serviceCollection"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	address, err := parseAddressParams(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseTimeParam(params.Get("from"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'from' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(params.Get("to"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'to' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		http.Error(w, "Invalid time range: 'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if value := params.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'limit' parameter %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := parsePage(limit, params.Get("sortBy"), params.Get("order"), params.Get("continuationToken"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := database.TrafficQuery{
+		Collections: collections,
+		Address:     address,
+		From:        from,
+		To:          to,
+		Filter:      database.TrafficFilter{Namespace: params.Get("namespace")},
+		Page:        page,
+	}
+	if acceptsNDJSON(r) {
+		h.streamTraffic(w, r, query)
+		return
+	}
+
+	results, err := h.store.AggregateTrafficWithService(r.Context(), query)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// parseAddressParams reads the address range from exactly one of the `ip`
+// and `cidr` query parameters.
+func parseAddressParams(params url.Values) (netip.Prefix, error) {
+	ip, cidr := params.Get("ip"), params.Get("cidr")
+	switch {
+	case ip != "" && cidr != "":
+		return netip.Prefix{}, errors.New("Only one of 'ip' and 'cidr' may be given")
+	case ip != "":
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Is4() {
+			return netip.Prefix{}, fmt.Errorf("Invalid 'ip' parameter %q: must be an IPv4 address", ip)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	case cidr != "":
+		prefix, err := database.ParseCIDR(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("Invalid 'cidr' parameter: %v", err)
+		}
+		return prefix, nil
+	default:
+		return netip.Prefix{}, errors.New("Missing 'ip' or 'cidr' parameter")
+	}
+}
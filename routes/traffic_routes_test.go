@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/m/internal/database"
+	"example.com/m/internal/graph"
+	"example.com/m/internal/network"
+	"example.com/m/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficByAddressRoute(t *testing.T) {
+	handler := newSampleHandler(t)
+
+	t.Run("UnregisteredIP", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/traffic?ip=121.23.41.1", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.TrafficPage
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		if assert.Len(t, response.Items, 1) {
+			item := response.Items[0]
+			assert.Nil(t, item.SourceService)
+			if assert.NotNil(t, item.DestinationService) {
+				assert.Equal(t, "Login Service", item.DestinationService.Name)
+			}
+			assert.Equal(t, database.DirectionOutbound, item.Direction)
+		}
+	})
+
+	t.Run("CIDR", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/traffic?cidr=10.128.72.0/24&limit=5&sortBy=source_ip", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.TrafficPage
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, int64(8), response.Total)
+		assert.Len(t, response.Items, 5)
+		assert.NotEmpty(t, response.ContinuationToken)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/traffic?cidr=10.128.24.0/24", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if assert.Len(t, lines, 1) {
+			var row database.ServiceTraffic
+			assert.NoError(t, json.Unmarshal([]byte(lines[0]), &row))
+			assert.Equal(t, database.DirectionInbound, row.Direction)
+		}
+	})
+
+	t.Run("InvalidParameters", func(t *testing.T) {
+		for _, url := range []string{
+			"/traffic",
+			"/traffic?ip=10.0.0.1&cidr=10.0.0.0/8",
+			"/traffic?ip=not-an-ip",
+			"/traffic?cidr=10.0.0.0/40",
+			"/traffic?ip=10.0.0.1&limit=many",
+		} {
+			req, err := http.NewRequest("GET", url, nil)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+		}
+	})
+}
+
+func TestNamespaceRoutes(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	store.CreateCollection("testdb", "traffic")
+	for _, svc := range []service.ServiceData{
+		{Name: "auth", Namespace: "prod", IPs: []string{"10.0.1.10"}},
+		{Name: "auth", Namespace: "staging", IPs: []string{"10.0.2.10"}},
+		{Name: "web", Namespace: "prod", IPs: []string{"10.0.1.20"}},
+	} {
+		assert.NoError(t, store.InsertService(ctx, "testdb", "services", svc))
+	}
+	for _, record := range []network.NetworkTraffic{
+		{SourceIP: "10.0.1.20", SourcePort: 40000, DestinationIP: "10.0.1.10", DestinationPort: 443, Status: network.StatusOK},
+		{SourceIP: "10.0.1.20", SourcePort: 40001, DestinationIP: "10.0.2.10", DestinationPort: 443, Status: network.StatusOK},
+	} {
+		assert.NoError(t, store.InsertTraffic(ctx, "testdb", "traffic", record))
+	}
+	handler := NewHandler(store, Options{Database: "testdb", NetworkCollection: "traffic", ServiceCollection: "services"})
+
+	postTraffic := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", "/TrafficService", bytes.NewBuffer(payload))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("AmbiguousServiceName", func(t *testing.T) {
+		rr := postTraffic(map[string]interface{}{"serviceName": "auth"})
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("ServiceNamespace", func(t *testing.T) {
+		rr := postTraffic(map[string]interface{}{"serviceName": "auth", "serviceNamespace": "staging"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.TrafficPage
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		if assert.Len(t, response.Items, 1) {
+			assert.Equal(t, "staging", response.Items[0].DestinationService.Namespace)
+		}
+	})
+
+	t.Run("FlowNamespace", func(t *testing.T) {
+		rr := postTraffic(map[string]interface{}{"serviceName": "web", "serviceNamespace": "prod", "namespace": "staging"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.TrafficPage
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		if assert.Len(t, response.Items, 1) {
+			assert.Equal(t, "10.0.2.10", response.Items[0].DestinationIP)
+		}
+	})
+
+	t.Run("StatsServiceNamespace", func(t *testing.T) {
+		for target, code := range map[string]int{
+			"/services/auth/stats":                          http.StatusConflict,
+			"/services/auth/stats?serviceNamespace=staging": http.StatusOK,
+		} {
+			req, err := http.NewRequest("GET", target, nil)
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			SetupRouter(handler).ServeHTTP(rr, req)
+			assert.Equal(t, code, rr.Code, target)
+		}
+	})
+
+	t.Run("GraphNamespace", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/graph?namespace=staging", nil)
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response graph.Graph
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, []graph.Edge{{Source: "service:prod/web", Target: "service:staging/auth", Flows: 1, Status: network.StatusOK}}, response.Edges)
+	})
+
+	t.Run("NamespaceFilter", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/traffic?cidr=10.0.0.0/16&namespace=staging", nil)
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		SetupRouter(handler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response database.TrafficPage
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		if assert.Len(t, response.Items, 1) {
+			assert.Equal(t, "10.0.2.10", response.Items[0].DestinationIP)
+		}
+	})
+}